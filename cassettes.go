@@ -0,0 +1,109 @@
+package gmeter
+
+import (
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const cassetteExt = ".cassette"
+
+//cassetteInfo describes a cassette file in the cassettes dir
+type cassetteInfo struct {
+	Name     string    `json:"name"`
+	Tracks   int       `json:"tracks"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+//Cassettes lists the cassettes (GET /gmeter/cassettes) or returns
+//the content of the cassette (GET /gmeter/cassettes/{name})
+func (rt *RoundTripper) Cassettes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/gmeter/cassettes"), "/")
+	if name == "" {
+		cassettes, err := listCassettes(rt.Options().CassettePath)
+		if err != nil {
+			rt.logger.Error("failed to list cassettes", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cassettes)
+		return
+	}
+
+	//the name of the cassette may contain slashes but it can't point outside of the dir
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(rt.Options().CassettePath, filepath.FromSlash(name)+cassetteExt))
+	if err != nil {
+		rt.logger.Warn("failed to read cassette", "cassette", name, "error", err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+//listCassettes returns the cassettes in the dir and its subdirs sorted by name
+func listCassettes(dir string) ([]cassetteInfo, error) {
+	cassettes := []cassetteInfo{}
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !strings.HasSuffix(path, cassetteExt) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		info := cassetteInfo{
+			Name:     filepath.ToSlash(strings.TrimSuffix(rel, cassetteExt)),
+			Size:     fi.Size(),
+			Modified: fi.ModTime(),
+		}
+
+		//the number of tracks is unknown if the cassette is broken
+		if data, err := ioutil.ReadFile(path); err == nil {
+			var c struct{ Tracks []json.RawMessage }
+			if json.Unmarshal(data, &c) == nil {
+				info.Tracks = len(c.Tracks)
+			}
+		}
+
+		cassettes = append(cassettes, info)
+		return nil
+	})
+
+	sort.Slice(cassettes, func(i, j int) bool {
+		return cassettes[i].Name < cassettes[j].Name
+	})
+
+	return cassettes, err
+}
@@ -0,0 +1,54 @@
+package gmeter
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRoundTripper_Cassettes(t *testing.T) {
+	dir := writeCassette(t, "users", cassetteTrack("GET", "/users", "[]", "", "", ""), cassetteTrack("GET", "/orders", "[]", "", "", ""))
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sub", "broken.cassette"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: Options{CassettePath: dir}}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "list", method: "GET", path: "/gmeter/cassettes", wantCode: http.StatusOK, wantBody: `"name":"sub/broken","tracks":0`},
+		{name: "list tracks", method: "GET", path: "/gmeter/cassettes/", wantCode: http.StatusOK, wantBody: `"name":"users","tracks":2`},
+		{name: "cassette", method: "GET", path: "/gmeter/cassettes/users", wantCode: http.StatusOK, wantBody: `"Name": "users"`},
+		{name: "not found", method: "GET", path: "/gmeter/cassettes/orders", wantCode: http.StatusNotFound},
+		{name: "outside of the dir", method: "GET", path: "/gmeter/cassettes/../users", wantCode: http.StatusBadRequest},
+		{name: "bad method", method: "POST", path: "/gmeter/cassettes", wantCode: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.URL.Path = tt.path
+
+			rt.Cassettes(w, r)
+
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("got: %d %s, want: %d %s", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
@@ -1,47 +1,146 @@
 package main
 
 import (
-	"log"
-	"net"
+	"context"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/hexdigest/gmeter"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "", log.LstdFlags)
-	errLog := log.New(os.Stderr, "", log.LstdFlags)
-
 	options := gmeter.GetOptions(os.Args[1:], os.Stdout, os.Stderr, os.Exit)
 
+	//the level can be changed by reloading the config file
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(options.LogLevel)
+
+	logger := gmeter.NewLogger(options.LogFormat, logLevel, os.Stdout)
+
 	rt := gmeter.NewRoundTripper(options, logger)
 
-	reverseProxy := httputil.NewSingleHostReverseProxy(options.TargetURL)
-	defaultDirector := reverseProxy.Director
-	reverseProxy.Director = func(r *http.Request) {
-		defaultDirector(r)
-		r.Host = options.TargetURL.Host
+	if options.StubsDir != "" {
+		if err := rt.LoadStubs(options.StubsDir); err != nil {
+			logger.Error("failed to load stubs", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	//the target changed in the config file is applied by the next session,
+	//so the director follows the target of the active session
+	type targetDirector struct {
+		target   *url.URL
+		director func(*http.Request)
+	}
+
+	var current atomic.Pointer[targetDirector]
+
+	reverseProxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			target := rt.Target()
+
+			d := current.Load()
+			if d == nil || d.target != target {
+				d = &targetDirector{target: target, director: httputil.NewSingleHostReverseProxy(target).Director}
+				current.Store(d)
+			}
+
+			d.director(r)
+			r.Host = target.Host
+		},
+	}
+
+	if err := rt.Start(); err != nil {
+		logger.Error("failed to start", "mode", options.Mode, "error", err)
+		os.Exit(1)
 	}
 
 	reverseProxy.Transport = rt
+	reverseProxy.ErrorHandler = rt.ErrorHandler
 
-	listener, err := net.Listen("tcp", options.ListenAddress)
+	listener, err := gmeter.Listen(options)
 	if err != nil {
-		errLog.Fatalf("failed to open socket: %v", err)
+		logger.Error("failed to open socket", "error", err)
+		os.Exit(1)
 	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/gmeter/record", rt.Record)
 	mux.HandleFunc("/gmeter/play", rt.Play)
+	mux.HandleFunc("/gmeter/passthrough", rt.Passthrough)
+	mux.HandleFunc("/gmeter/faults", rt.Faults)
+	mux.HandleFunc("/gmeter/stubs", rt.Stubs)
+	mux.HandleFunc("/gmeter/scenarios", rt.Scenarios)
+	mux.HandleFunc("/gmeter/metrics", rt.Metrics)
+	mux.HandleFunc("/gmeter/exchanges", rt.Exchanges)
+	mux.HandleFunc("/gmeter/cassettes", rt.Cassettes)
+	mux.HandleFunc("/gmeter/cassettes/", rt.Cassettes)
+	mux.HandleFunc("/gmeter/ui", rt.UI)
 	mux.HandleFunc("/", reverseProxy.ServeHTTP)
 
+	//HTTP/2 is negotiated over TLS, unencrypted HTTP/2 is accepted
+	//from the clients that use prior knowledge, e.g. plaintext gRPC
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
 	server := http.Server{
-		Handler:  mux,
-		ErrorLog: errLog,
+		Handler:   mux,
+		ErrorLog:  slog.NewLogLogger(logger.Handler(), slog.LevelError),
+		Protocols: protocols,
 	}
+	server.RegisterOnShutdown(rt.Drain)
 
-	logger.Printf("started proxy %s -> %s", options.ListenAddress, options.TargetURL)
-	server.Serve(listener)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if options.ConfigFile != "" {
+		go gmeter.WatchConfig(ctx, os.Args[1:], options.ConfigFile, time.Second, logger, func(o gmeter.Options) {
+			logLevel.Set(o.LogLevel)
+			rt.Reload(o)
+		})
+	}
+
+	logger.Info("started proxy", "listen", options.ListenAddress, "target", options.TargetURL.String())
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-served:
+		logger.Error("failed to serve", "error", err)
+		os.Exit(1)
+	case <-ctx.Done():
+		//the second signal kills the process without waiting
+		stop()
+	}
+
+	//stop accepting new connections and wait for the in-flight exchanges
+	timeout := rt.Options().ShutdownTimeout
+	logger.Info("shutting down", "timeout", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	//the requests that are still in flight after the timeout are canceled
+	//so that nothing holds the shutdown any longer
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("in-flight requests are interrupted", "error", err)
+		server.Close()
+	}
+
+	if err := rt.Close(); err != nil {
+		logger.Error("failed to shut down", "error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,263 @@
+package gmeter
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+//envPrefix is the prefix of the env variables that override the config file
+const envPrefix = "GMETER_"
+
+//configFlags maps the keys of the config file to the flags
+var configFlags = map[string]string{
+	"listen":        "l",
+	"target":        "t",
+	"dir":           "d",
+	"insecure":      "insecure",
+	"tls":           "tls",
+	"tlsCert":       "tls-cert",
+	"tlsKey":        "tls-key",
+	"clientCert":    "client-cert",
+	"clientKey":     "client-key",
+	"ca":            "ca",
+	"tlsMinVersion": "tls-min-version",
+	"sni":           "sni",
+	"upstreamProxy": "upstream-proxy",
+	"h2c":           "h2c",
+	"stubs":         "stubs",
+	"logFormat":     "log-format",
+	"logLevel":      "log-level",
+	"mode":          "mode",
+	"cassette":      "cassette",
+
+	"shutdownTimeout": "shutdown-timeout",
+}
+
+//restartOptions are the options that can't be changed without a restart
+var restartOptions = []string{"listen", "tls", "tlsCert", "tlsKey", "logFormat", "stubs", "mode", "cassette"}
+
+type lookupEnvFunc func(string) (string, bool)
+
+//applyConfig sets the flags that are not set in the arguments to the values
+//from the env variables or from the config file and returns the errors
+func applyConfig(flagset *flag.FlagSet, filename string, lookupEnv lookupEnvFunc) []string {
+	var errors []string
+
+	values := map[string][]string{}
+	if filename != "" {
+		fileValues, err := readConfig(filename)
+		if err != nil {
+			errors = append(errors, err.Error())
+		}
+
+		for name, value := range fileValues {
+			values[name] = value
+		}
+	}
+
+	for key, name := range configFlags {
+		if value, ok := lookupEnv(envName(key)); ok {
+			if name == "ca" {
+				values[name] = strings.Split(value, ",")
+			} else {
+				values[name] = []string{value}
+			}
+		}
+	}
+
+	set := map[string]bool{}
+	flagset.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if set[name] {
+			continue
+		}
+
+		for _, value := range values[name] {
+			if err := flagset.Set(name, value); err != nil {
+				errors = append(errors, fmt.Sprintf("invalid value %q of -%s: %v", value, name, err))
+			}
+		}
+	}
+
+	return errors
+}
+
+//envName returns the name of the env variable that overrides the config key,
+//e.g. tlsMinVersion is overridden by GMETER_TLS_MIN_VERSION
+func envName(key string) string {
+	var b strings.Builder
+	b.WriteString(envPrefix)
+	for _, r := range key {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+		}
+		b.WriteRune(r)
+	}
+
+	return strings.ToUpper(b.String())
+}
+
+//readConfig reads the JSON config file and returns the values of the flags
+func readConfig(filename string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	return parseConfig(data)
+}
+
+func parseConfig(data []byte) (map[string][]string, error) {
+	var config map[string]json.RawMessage
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	values := map[string][]string{}
+	for key, raw := range config {
+		name, ok := configFlags[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown config key: %q", key)
+		}
+
+		value, err := configValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of %q: %v", key, err)
+		}
+
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+//configValue converts the JSON string, bool, number or array of strings
+//to the flag values
+func configValue(raw json.RawMessage) ([]string, error) {
+	var value interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool, json.Number:
+		return []string{fmt.Sprint(v)}, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("array items should be strings")
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("should be a string, bool, number or array of strings")
+}
+
+//ReloadOptions parses the arguments along with the config file and the env variables
+//the same way GetOptions does but returns the errors instead of exiting
+func ReloadOptions(arguments []string) (Options, error) {
+	_, options, _, errors := parseOptions(arguments)
+	if len(errors) > 0 {
+		return Options{}, fmt.Errorf("%s", strings.Join(errors, "; "))
+	}
+
+	return options, nil
+}
+
+//WatchConfig checks the config file every interval and calls reload with
+//the new options when the file changes, invalid configs are logged and ignored
+func WatchConfig(ctx context.Context, arguments []string, filename string, interval time.Duration, logger *slog.Logger, reload func(Options)) {
+	current, _ := ioutil.ReadFile(filename)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		data, err := ioutil.ReadFile(filename)
+		if err != nil || bytes.Equal(data, current) {
+			continue
+		}
+		current = data
+
+		options, err := ReloadOptions(arguments)
+		if err != nil {
+			logger.Error("failed to reload config", "config", filename, "error", err)
+			continue
+		}
+
+		logger.Info("reloaded config", "config", filename)
+		reload(options)
+	}
+}
+
+//Reload replaces the options, the active session keeps the options
+//it was started with and the new ones are used by the next sessions
+func (rt *RoundTripper) Reload(options Options) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	changed := map[string]bool{
+		"listen":    options.ListenAddress != rt.options.ListenAddress,
+		"tls":       options.TLS != rt.options.TLS,
+		"tlsCert":   options.TLSCertFile != rt.options.TLSCertFile,
+		"tlsKey":    options.TLSKeyFile != rt.options.TLSKeyFile,
+		"logFormat": options.LogFormat != rt.options.LogFormat,
+		"stubs":     options.StubsDir != rt.options.StubsDir,
+		"mode":      options.Mode != rt.options.Mode,
+		"cassette":  options.Cassette != rt.options.Cassette,
+	}
+
+	for _, key := range restartOptions {
+		if changed[key] {
+			rt.logger.Warn("config option requires a restart to take effect", "option", key)
+		}
+	}
+
+	rt.options = options
+}
+
+//Options returns the current options
+func (rt *RoundTripper) Options() Options {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+
+	return rt.options
+}
+
@@ -0,0 +1,181 @@
+package gmeter
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, config string) string {
+	filename := filepath.Join(t.TempDir(), "gmeter.json")
+	if err := os.WriteFile(filename, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func Test_applyConfig(t *testing.T) {
+	tests := []struct {
+		name       string
+		arguments  []string
+		config     string
+		env        map[string]string
+		want       map[string]string
+		wantErrors int
+	}{
+		{
+			name:   "config file",
+			config: `{"target": "http://example.com", "insecure": true, "ca": ["a.pem", "b.pem"], "logLevel": "debug"}`,
+			want:   map[string]string{"t": "http://example.com", "insecure": "true", "ca": "a.pem,b.pem", "log-level": "debug"},
+		},
+		{
+			name:   "env overrides config file",
+			config: `{"target": "http://example.com", "tlsMinVersion": "1.2"}`,
+			env:    map[string]string{"GMETER_TARGET": "http://example.org", "GMETER_CA": "c.pem,d.pem"},
+			want:   map[string]string{"t": "http://example.org", "tls-min-version": "1.2", "ca": "c.pem,d.pem"},
+		},
+		{
+			name:      "arguments override env and config file",
+			arguments: []string{"-t", "http://localhost"},
+			config:    `{"target": "http://example.com"}`,
+			env:       map[string]string{"GMETER_TARGET": "http://example.org"},
+			want:      map[string]string{"t": "http://localhost"},
+		},
+		{
+			name:       "unknown key",
+			config:     `{"targets": "http://example.com"}`,
+			wantErrors: 1,
+		},
+		{
+			name:       "invalid value",
+			config:     `{"insecure": "maybe"}`,
+			wantErrors: 1,
+		},
+		{
+			name:       "unsupported type",
+			config:     `{"target": {"url": "http://example.com"}}`,
+			wantErrors: 1,
+		},
+		{
+			name:       "broken file",
+			config:     `{`,
+			wantErrors: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rootCAs stringsFlag
+
+			flagset := flag.NewFlagSet("gmeter", flag.ContinueOnError)
+			flagset.String("t", "", "")
+			flagset.Bool("insecure", false, "")
+			flagset.String("tls-min-version", "", "")
+			flagset.String("log-level", "info", "")
+			flagset.Var(&rootCAs, "ca", "")
+			flagset.Parse(tt.arguments)
+
+			lookupEnv := func(name string) (string, bool) {
+				value, ok := tt.env[name]
+				return value, ok
+			}
+
+			errors := applyConfig(flagset, writeConfig(t, tt.config), lookupEnv)
+			if len(errors) != tt.wantErrors {
+				t.Fatalf("unexpected errors: %v", errors)
+			}
+
+			for name, want := range tt.want {
+				if got := flagset.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s got: %q, want: %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_envName(t *testing.T) {
+	for key, want := range map[string]string{"target": "GMETER_TARGET", "tlsMinVersion": "GMETER_TLS_MIN_VERSION"} {
+		if got := envName(key); got != want {
+			t.Errorf("envName(%q) got: %q, want: %q", key, got, want)
+		}
+	}
+}
+
+func TestRoundTripper_Reload(t *testing.T) {
+	oldTarget := &url.URL{Scheme: "http", Host: "a.example.com"}
+	newTarget := &url.URL{Scheme: "http", Host: "b.example.com"}
+
+	rt := &RoundTripper{
+		logger:   slog.New(slog.DiscardHandler),
+		options:  Options{CassettePath: "a", TargetURL: oldTarget},
+		session:  "s1",
+		mode:     modePlay,
+		cassette: "users",
+		target:   oldTarget,
+	}
+
+	rt.Reload(Options{CassettePath: "b", TargetURL: newTarget})
+
+	if rt.Options().CassettePath != "b" {
+		t.Errorf("options are not reloaded")
+	}
+
+	if rt.session != "s1" || rt.mode != modePlay || rt.cassette != "users" || rt.Target() != oldTarget {
+		t.Errorf("session is not kept: %s %s %s %s", rt.session, rt.mode, rt.cassette, rt.Target())
+	}
+
+	//the new target is applied by the next session
+	if err := rt.passthrough(); err != nil {
+		t.Fatalf("passthrough failed: %v", err)
+	}
+
+	if rt.Target() != newTarget {
+		t.Errorf("unexpected target of the next session: %s", rt.Target())
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	filename := writeConfig(t, `{"target": "http://example.com"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloaded := make(chan Options, 1)
+	go WatchConfig(ctx, []string{"-c", filename}, filename, 10*time.Millisecond, slog.New(slog.DiscardHandler), func(o Options) {
+		reloaded <- o
+	})
+
+	time.Sleep(30 * time.Millisecond)
+
+	//invalid config is ignored
+	if err := ioutil.WriteFile(filename, []byte(`{"target": "ftp://example.com"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if err := ioutil.WriteFile(filename, []byte(`{"target": "http://example.org", "dir": "cassettes"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case o := <-reloaded:
+		if o.TargetURL.String() != "http://example.org" || o.CassettePath != "cassettes" || o.ConfigFile != filename {
+			t.Errorf("unexpected options: %+v", o)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("config is not reloaded")
+	}
+
+	if got, err := ReloadOptions([]string{"-c", filename, "-d", "."}); err != nil || got.CassettePath != "." {
+		t.Errorf("unexpected result: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,66 @@
+package gmeter
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+//failure is a kind of the transport error observed when a track was recorded
+type failure int
+
+const (
+	failureUnknown failure = iota
+	failureRefused
+	failureTimeout
+	failureReset
+	failureTLS
+)
+
+var failureNames = map[failure]string{
+	failureUnknown: "unknown",
+	failureRefused: "connection refused",
+	failureTimeout: "timeout",
+	failureReset:   "connection reset",
+	failureTLS:     "TLS failure",
+}
+
+func (f failure) String() string {
+	return failureNames[f]
+}
+
+//classifyFailure guesses what happened to the connection to the target by the recorded error
+func classifyFailure(err *govcr.TrackError) failure {
+	msg := strings.ToLower(err.Msg)
+
+	switch {
+	case strings.Contains(msg, "connection refused"):
+		return failureRefused
+	case strings.Contains(msg, "timeout"), strings.Contains(msg, "deadline exceeded"):
+		return failureTimeout
+	case strings.Contains(msg, "connection reset"), strings.Contains(msg, "broken pipe"), strings.HasSuffix(msg, "eof"):
+		return failureReset
+	case strings.Contains(msg, "tls:"), strings.Contains(msg, "x509:"), strings.Contains(err.Type, "tls."), strings.Contains(err.Type, "x509."):
+		return failureTLS
+	}
+
+	return failureUnknown
+}
+
+//replayFailure reproduces the failure on the client connection
+func replayFailure(w http.ResponseWriter, r *http.Request, f failure) {
+	switch f {
+	case failureRefused, failureReset:
+		//the client is already connected to gmeter so the best we can do
+		//for the refused connection is to reset it right away
+		resetConnection(w)
+	case failureTimeout:
+		<-r.Context().Done()
+		panic(http.ErrAbortHandler)
+	case failureTLS:
+		//the handshake with the client is over by the time the request is known,
+		//so the TLS failure can only be approximated by resetting the connection
+		resetConnection(w)
+	}
+}
@@ -0,0 +1,158 @@
+package gmeter
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+func Test_classifyFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  govcr.TrackError
+		want failure
+	}{
+		{
+			name: "refused",
+			err:  govcr.TrackError{Type: "*net.OpError", Msg: "dial tcp 127.0.0.1:1: connect: connection refused"},
+			want: failureRefused,
+		},
+		{
+			name: "dial timeout",
+			err:  govcr.TrackError{Type: "*net.OpError", Msg: "dial tcp 10.0.0.1:80: i/o timeout"},
+			want: failureTimeout,
+		},
+		{
+			name: "TLS handshake timeout",
+			err:  govcr.TrackError{Type: "*errors.errorString", Msg: "net/http: TLS handshake timeout"},
+			want: failureTimeout,
+		},
+		{
+			name: "reset",
+			err:  govcr.TrackError{Type: "*net.OpError", Msg: "read tcp 127.0.0.1:5000->127.0.0.1:80: read: connection reset by peer"},
+			want: failureReset,
+		},
+		{
+			name: "EOF",
+			err:  govcr.TrackError{Type: "*errors.errorString", Msg: "EOF"},
+			want: failureReset,
+		},
+		{
+			name: "unknown authority",
+			err:  govcr.TrackError{Type: "*tls.CertificateVerificationError", Msg: "tls: failed to verify certificate: x509: certificate signed by unknown authority"},
+			want: failureTLS,
+		},
+		{
+			name: "unknown",
+			err:  govcr.TrackError{Type: "*errors.errorString", Msg: "something went wrong"},
+			want: failureUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFailure(&tt.err); got != tt.want {
+				t.Errorf("classifyFailure got: %s, want: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_ErrorHandler_recordedFailures(t *testing.T) {
+	//the client sees the connection closed without a response, not a protocol error
+	isReset := func(err error) bool {
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || strings.HasSuffix(err.Error(), "EOF")
+	}
+
+	isTimeout := func(err error) bool {
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+
+	tests := []struct {
+		name string
+		err  error
+
+		wantErr    func(error) bool
+		wantStatus int
+	}{
+		{
+			name:    "reset",
+			err:     &govcr.TrackError{Type: "*net.OpError", Msg: "read: connection reset by peer"},
+			wantErr: isReset,
+		},
+		{
+			name:    "TLS failure",
+			err:     &govcr.TrackError{Type: "*tls.CertificateVerificationError", Msg: "tls: failed to verify certificate"},
+			wantErr: isReset,
+		},
+		{
+			name:    "timeout",
+			err:     &govcr.TrackError{Type: "*net.OpError", Msg: "i/o timeout"},
+			wantErr: isTimeout,
+		},
+		{
+			name:       "unknown",
+			err:        &govcr.TrackError{Type: "*errors.errorString", Msg: "something went wrong"},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tt := range tests {
+		for _, scheme := range []string{"http", "https"} {
+			t.Run(tt.name+" over "+scheme, func(t *testing.T) {
+				rt := &RoundTripper{logger: slog.New(slog.DiscardHandler)}
+
+				server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					rt.ErrorHandler(w, r, tt.err)
+				}))
+				server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+
+				if scheme == "https" {
+					server.StartTLS()
+				} else {
+					server.Start()
+				}
+				defer server.Close()
+
+				client := server.Client()
+				client.Timeout = 100 * time.Millisecond
+
+				resp, err := client.Get(server.URL)
+
+				if tt.wantErr == nil {
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+
+					resp.Body.Close()
+					if resp.StatusCode != tt.wantStatus {
+						t.Errorf("unexpected status code, got: %d, want: %d", resp.StatusCode, tt.wantStatus)
+					}
+
+					return
+				}
+
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("unexpected response: %d", resp.StatusCode)
+				}
+
+				if !tt.wantErr(err) {
+					t.Errorf("unexpected error: %v", err)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package gmeter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var errConnectionReset = errors.New("connection reset by fault injection rule")
+
+type (
+	//faultRule describes a fault that is injected into the exchange when
+	//a request matches Method and Path (regular expression)
+	faultRule struct {
+		Method string `json:"method,omitempty"`
+		Path   string `json:"path,omitempty"`
+
+		//Probability of the fault, 1 if not set
+		Probability float64 `json:"probability,omitempty"`
+
+		//Latency is added before any other fault is applied
+		Latency duration `json:"latency,omitempty"`
+
+		//Status and Body are returned instead of the real response
+		Status int    `json:"status,omitempty"`
+		Body   string `json:"body,omitempty"`
+
+		//Reset closes the client connection without a response
+		Reset bool `json:"reset,omitempty"`
+
+		//Stall holds the request until the client gives up
+		Stall bool `json:"stall,omitempty"`
+
+		//Truncate is a number of bytes of the response body that are
+		//sent to the client before the connection is aborted
+		Truncate *int64 `json:"truncate,omitempty"`
+
+		path *regexp.Regexp
+	}
+
+	//truncatedBody stops reading after the limit is reached, if the
+	//length of the body is unknown it returns io.ErrUnexpectedEOF so
+	//the connection is aborted, otherwise the server closes the
+	//connection itself because of the short body
+	truncatedBody struct {
+		io.ReadCloser
+		limit int64
+		err   error
+	}
+)
+
+func (f *faultRule) validate() error {
+	if f.Probability < 0 || f.Probability > 1 {
+		return fmt.Errorf("probability should be in range [0, 1]: %v", f.Probability)
+	}
+
+	if f.Latency < 0 {
+		return errors.New("latency can't be negative")
+	}
+
+	if f.Status != 0 && (f.Status < 100 || f.Status > 999) {
+		return fmt.Errorf("invalid status code: %d", f.Status)
+	}
+
+	if f.Truncate != nil && *f.Truncate < 0 {
+		return errors.New("truncate can't be negative")
+	}
+
+	if f.Status == 0 && !f.Reset && !f.Stall && f.Truncate == nil && f.Latency == 0 {
+		return errors.New("fault rule should have at least one of: latency, status, reset, stall, truncate")
+	}
+
+	path, err := regexp.Compile(f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to compile path: %v", err)
+	}
+
+	f.path = path
+	return nil
+}
+
+func (f *faultRule) matches(r *http.Request) bool {
+	if f.Method != "" && !strings.EqualFold(f.Method, r.Method) {
+		return false
+	}
+
+	if !f.path.MatchString(r.URL.Path) {
+		return false
+	}
+
+	return f.Probability == 0 || rand.Float64() < f.Probability
+}
+
+//roundTrip applies the fault to the exchange, next is called
+//only if the fault doesn't replace the response
+func (f *faultRule) roundTrip(r *http.Request, next http.RoundTripper) (*http.Response, error) {
+	if f.Latency > 0 {
+		select {
+		case <-time.After(time.Duration(f.Latency)):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+
+	switch {
+	case f.Reset:
+		return nil, errConnectionReset
+	case f.Stall:
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	case f.Status != 0:
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+			StatusCode:    f.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{},
+			Body:          ioutil.NopCloser(strings.NewReader(f.Body)),
+			ContentLength: int64(len(f.Body)),
+			Request:       r,
+		}, nil
+	}
+
+	resp, err := next.RoundTrip(r)
+	if err != nil || f.Truncate == nil {
+		return resp, err
+	}
+
+	truncated := &truncatedBody{ReadCloser: resp.Body, limit: *f.Truncate, err: io.EOF}
+	if resp.ContentLength < 0 {
+		truncated.err = io.ErrUnexpectedEOF
+	}
+
+	resp.Body = truncated
+	return resp, nil
+}
+
+//Read implements io.Reader
+func (tb *truncatedBody) Read(p []byte) (int, error) {
+	if tb.limit <= 0 {
+		return 0, tb.err
+	}
+
+	if int64(len(p)) > tb.limit {
+		p = p[:tb.limit]
+	}
+
+	n, err := tb.ReadCloser.Read(p)
+	tb.limit -= int64(n)
+
+	return n, err
+}
+
+//matchFault returns the first rule that matches the request
+func matchFault(faults []faultRule, r *http.Request) *faultRule {
+	for i := range faults {
+		if faults[i].matches(r) {
+			return &faults[i]
+		}
+	}
+
+	return nil
+}
+
+//Faults lists (GET), adds (POST) or removes all (DELETE) fault injection rules
+func (rt *RoundTripper) Faults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rt.lock.RLock()
+		defer rt.lock.RUnlock()
+
+		rules := rt.faults
+		if rules == nil {
+			rules = []faultRule{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPost:
+		var rule faultRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			rt.logger.Error("failed to decode fault rule", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		if err := rule.validate(); err != nil {
+			rt.logger.Error("invalid fault rule", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+
+		rt.faults = append(rt.faults, rule)
+		rt.logger.Info("added fault rule", "method", rule.Method, "path", rule.Path)
+	case http.MethodDelete:
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+
+		rt.faults = nil
+		rt.logger.Info("removed all fault rules")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,250 @@
+package gmeter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func int64Ptr(i int64) *int64 {
+	return &i
+}
+
+func Test_faultRule_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    faultRule
+		wantErr bool
+	}{
+		{name: "no faults", rule: faultRule{Path: "/"}, wantErr: true},
+		{name: "bad probability", rule: faultRule{Status: 500, Probability: 2}, wantErr: true},
+		{name: "bad status", rule: faultRule{Status: 42}, wantErr: true},
+		{name: "negative latency", rule: faultRule{Latency: -1}, wantErr: true},
+		{name: "negative truncate", rule: faultRule{Truncate: int64Ptr(-1)}, wantErr: true},
+		{name: "bad path", rule: faultRule{Status: 500, Path: "("}, wantErr: true},
+		{name: "success", rule: faultRule{Status: 500, Path: "^/users", Probability: 0.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("faultRule.validate error = %v, wantErr: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_faultRule_roundTrip(t *testing.T) {
+	upstream := roundTripperMock{resp: &http.Response{
+		StatusCode:    http.StatusOK,
+		Body:          ioutil.NopCloser(strings.NewReader("hello world")),
+		ContentLength: -1,
+	}}
+
+	tests := []struct {
+		name    string
+		rule    faultRule
+		request func(t *testing.T) *http.Request
+		inspect func(resp *http.Response, err error, t *testing.T)
+	}{
+		{
+			name:    "reset",
+			rule:    faultRule{Reset: true},
+			request: func(t *testing.T) *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			inspect: func(resp *http.Response, err error, t *testing.T) {
+				if err != errConnectionReset {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "stall",
+			rule: faultRule{Stall: true},
+			request: func(t *testing.T) *http.Request {
+				r := httptest.NewRequest("GET", "/", nil)
+				ctx, cancel := context.WithTimeout(r.Context(), 10*time.Millisecond)
+				t.Cleanup(cancel)
+				return r.WithContext(ctx)
+			},
+			inspect: func(resp *http.Response, err error, t *testing.T) {
+				if err == nil {
+					t.Errorf("expected error")
+				}
+			},
+		},
+		{
+			name:    "status",
+			rule:    faultRule{Status: http.StatusServiceUnavailable, Body: "unavailable", Latency: duration(time.Millisecond)},
+			request: func(t *testing.T) *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			inspect: func(resp *http.Response, err error, t *testing.T) {
+				if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+					t.Fatalf("unexpected response: %v, %v", resp, err)
+				}
+
+				body, _ := ioutil.ReadAll(resp.Body)
+				if string(body) != "unavailable" {
+					t.Errorf("unexpected body: %q", body)
+				}
+			},
+		},
+		{
+			name:    "truncate",
+			rule:    faultRule{Truncate: int64Ptr(5)},
+			request: func(t *testing.T) *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			inspect: func(resp *http.Response, err error, t *testing.T) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				body, err := ioutil.ReadAll(resp.Body)
+				if err != io.ErrUnexpectedEOF || string(body) != "hello" {
+					t.Errorf("unexpected body: %q, error: %v", body, err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rule.validate(); err != nil {
+				t.Fatalf("invalid rule: %v", err)
+			}
+
+			resp, err := tt.rule.roundTrip(tt.request(t), upstream)
+			tt.inspect(resp, err, t)
+		})
+	}
+}
+
+func TestRoundTripper_Faults(t *testing.T) {
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler)}
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "empty list", method: "GET", wantCode: http.StatusOK, wantBody: "[]\n"},
+		{name: "bad json", method: "POST", body: "{", wantCode: http.StatusBadRequest},
+		{name: "invalid rule", method: "POST", body: `{"path": "/"}`, wantCode: http.StatusBadRequest},
+		{name: "add rule", method: "POST", body: `{"method": "GET", "path": "^/users", "status": 500, "latency": "1s"}`, wantCode: http.StatusOK},
+		{name: "list", method: "GET", wantCode: http.StatusOK, wantBody: `[{"method":"GET","path":"^/users","latency":"1s","status":500}]` + "\n"},
+		{name: "bad method", method: "PUT", wantCode: http.StatusMethodNotAllowed},
+		{name: "delete", method: "DELETE", wantCode: http.StatusOK},
+		{name: "deleted", method: "GET", wantCode: http.StatusOK, wantBody: "[]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.Faults(w, httptest.NewRequest(tt.method, "/gmeter/faults", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("unexpected status code, got: %d, want: %d", w.Code, tt.wantCode)
+			}
+
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("unexpected body, got: %s, want: %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_stall(t *testing.T) {
+	rule := faultRule{Path: "^/slow", Stall: true}
+	if err := rule.validate(); err != nil {
+		t.Fatalf("invalid rule: %v", err)
+	}
+
+	rt := &RoundTripper{
+		RoundTripper: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		}),
+		logger: slog.New(slog.DiscardHandler),
+		mode:   modePassthrough,
+		faults: []faultRule{rule},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stalled := make(chan error, 1)
+	go func() {
+		_, err := rt.RoundTrip(httptest.NewRequest("GET", "http://example.com/slow", nil).WithContext(ctx))
+		stalled <- err
+	}()
+
+	//let the stalled request start
+	time.Sleep(50 * time.Millisecond)
+
+	//the control calls and the other requests shouldn't wait for the stalled request
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		w := httptest.NewRecorder()
+		rt.Faults(w, httptest.NewRequest("DELETE", "/gmeter/faults", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("unexpected status code: %d", w.Code)
+		}
+
+		resp, err := rt.RoundTrip(httptest.NewRequest("GET", "http://example.com/fast", nil))
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected result of the fast request: %v", err)
+		}
+
+		if err := rt.Close(); err != nil {
+			t.Errorf("failed to close: %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("control calls are blocked by the stalled request")
+	}
+
+	select {
+	case err := <-stalled:
+		t.Fatalf("stalled request is done before it's canceled: %v", err)
+	default:
+	}
+
+	cancel()
+
+	if err := <-stalled; err != context.Canceled {
+		t.Errorf("unexpected error of the stalled request: %v", err)
+	}
+}
+
+func TestRoundTripper_ErrorHandler(t *testing.T) {
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler)}
+
+	t.Run("proxy error", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		rt.ErrorHandler(w, httptest.NewRequest("GET", "/", nil), errors.New("bad gateway"))
+		if w.Code != http.StatusBadGateway {
+			t.Errorf("unexpected status code: %d", w.Code)
+		}
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r != http.ErrAbortHandler {
+				t.Errorf("expected handler to be aborted, got: %v", r)
+			}
+		}()
+
+		rt.ErrorHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), errConnectionReset)
+	})
+}
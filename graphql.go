@@ -0,0 +1,193 @@
+package gmeter
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type (
+	//graphQL describes how GraphQL requests are matched against the cassette:
+	//bodies are compared by the operation name, the normalized query document
+	//and the variables except for the ignored ones.
+	//IgnoreVariables contains variable names or dot separated paths to the
+	//nested fields, e.g. "input.clientMutationId"
+	graphQL struct {
+		IgnoreVariables []string `json:"ignoreVariables"`
+	}
+
+	graphQLRequest struct {
+		OperationName string                 `json:"operationName,omitempty"`
+		Query         string                 `json:"query"`
+		Variables     map[string]interface{} `json:"variables,omitempty"`
+		Extensions    map[string]interface{} `json:"extensions,omitempty"`
+	}
+)
+
+//graphQLPunctuators are the characters that are tokens on their own,
+//the spread operator "..." is handled separately
+const graphQLPunctuators = "!$&()[]{}:=@|"
+
+//filter is a govcr.RequestFilterFunc that replaces the body of the GraphQL
+//request (or a batch of requests) with its canonical form, other bodies are
+//returned as is. Content-Length of the original body is dropped since it
+//depends on the formatting of the request
+func (g graphQL) filter(header http.Header, body []byte) (*http.Header, *[]byte) {
+	canonical, ok := g.canonicalize(body)
+	if !ok {
+		return &header, &body
+	}
+
+	//the header is shared with the request or the track
+	header = header.Clone()
+	header.Del("Content-Length")
+
+	return &header, &canonical
+}
+
+func (g graphQL) canonicalize(body []byte) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+
+	if trimmed[0] == '[' {
+		var batch []graphQLRequest
+		if err := decoder.Decode(&batch); err != nil || len(batch) == 0 {
+			return nil, false
+		}
+
+		for i := range batch {
+			if !g.normalize(&batch[i]) {
+				return nil, false
+			}
+		}
+
+		canonical, err := json.Marshal(batch)
+		return canonical, err == nil
+	}
+
+	var req graphQLRequest
+	if err := decoder.Decode(&req); err != nil || !g.normalize(&req) {
+		return nil, false
+	}
+
+	canonical, err := json.Marshal(req)
+	return canonical, err == nil
+}
+
+//normalize brings the request to the canonical form, json.Marshal takes
+//care of the order of the variables since it sorts map keys
+func (g graphQL) normalize(req *graphQLRequest) bool {
+	if req.Query == "" {
+		return false
+	}
+
+	req.Query = normalizeQuery(req.Query)
+
+	for _, path := range g.IgnoreVariables {
+		deletePath(req.Variables, strings.Split(path, "."))
+	}
+
+	return true
+}
+
+//normalizeQuery returns the tokens of the GraphQL document separated by a
+//single space, so that insignificant whitespace, commas and comments are ignored
+func normalizeQuery(query string) string {
+	var tokens []string
+
+	for i := 0; i < len(query); {
+		c := query[i]
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			end := strings.IndexAny(query[i:], "\r\n")
+			if end < 0 {
+				return strings.Join(tokens, " ")
+			}
+			i += end
+		case strings.HasPrefix(query[i:], `"""`):
+			end := blockStringEnd(query, i+3)
+			tokens = append(tokens, query[i:end])
+			i = end
+		case c == '"':
+			end := stringEnd(query, i+1)
+			tokens = append(tokens, query[i:end])
+			i = end
+		case strings.HasPrefix(query[i:], "..."):
+			tokens = append(tokens, "...")
+			i += 3
+		case strings.IndexByte(graphQLPunctuators, c) >= 0:
+			tokens = append(tokens, string(c))
+			i++
+		default:
+			end := i + 1
+			for end < len(query) && !isGraphQLDelimiter(query[end]) {
+				end++
+			}
+			tokens = append(tokens, query[i:end])
+			i = end
+		}
+	}
+
+	return strings.Join(tokens, " ")
+}
+
+func isGraphQLDelimiter(c byte) bool {
+	return strings.IndexByte(" \t\r\n,#\"", c) >= 0 || strings.IndexByte(graphQLPunctuators, c) >= 0
+}
+
+//stringEnd returns the position after the closing quote of the string
+//that starts at the given position
+func stringEnd(query string, start int) int {
+	for i := start; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			i++
+		case '"', '\n':
+			return i + 1
+		}
+	}
+
+	return len(query)
+}
+
+//blockStringEnd returns the position after the closing triple quote of the
+//block string that starts at the given position
+func blockStringEnd(query string, start int) int {
+	for i := start; i < len(query); i++ {
+		if strings.HasPrefix(query[i:], `\"""`) {
+			i += 3
+			continue
+		}
+
+		if strings.HasPrefix(query[i:], `"""`) {
+			return i + 3
+		}
+	}
+
+	return len(query)
+}
+
+//deletePath removes the value at the path from the nested objects
+func deletePath(m map[string]interface{}, path []string) {
+	if m == nil || len(path) == 0 {
+		return
+	}
+
+	if len(path) == 1 {
+		delete(m, path[0])
+		return
+	}
+
+	if nested, ok := m[path[0]].(map[string]interface{}); ok {
+		deletePath(nested, path[1:])
+	}
+}
@@ -0,0 +1,163 @@
+package gmeter
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_normalizeQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "whitespace and commas",
+			query: "query  User($id: ID!,\n $first: Int) {\n\tuser(id: $id) { name, friends(first: $first) { name } }\n}",
+			want:  "query User ( $ id : ID ! $ first : Int ) { user ( id : $ id ) { name friends ( first : $ first ) { name } } }",
+		},
+		{
+			name:  "comments",
+			query: "# fetch the user\n{ user { name # the name\n } }",
+			want:  "{ user { name } }",
+		},
+		{
+			name:  "strings are preserved",
+			query: `{ search(text: "a,  b # c \" d") { id } }`,
+			want:  `{ search ( text : "a,  b # c \" d" ) { id } }`,
+		},
+		{
+			name:  "block strings are preserved",
+			query: "{ search(text: \"\"\"a,\n  \\\"\"\" b\"\"\") { id } }",
+			want:  "{ search ( text : \"\"\"a,\n  \\\"\"\" b\"\"\" ) { id } }",
+		},
+		{
+			name:  "fragments",
+			query: "{ node { ...UserFields ... on User { id } } }",
+			want:  "{ node { ... UserFields ... on User { id } } }",
+		},
+		{
+			name:  "numbers",
+			query: "{ items(price: -1.5e+3) { id } }",
+			want:  "{ items ( price : -1.5e+3 ) { id } }",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeQuery(tt.query); got != tt.want {
+				t.Errorf("normalizeQuery got: %q, want: %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_graphQL_filter(t *testing.T) {
+	tests := []struct {
+		name    string
+		graphQL graphQL
+		body1   string
+		body2   string
+		want    bool
+	}{
+		{
+			name:  "not a GraphQL request",
+			body1: `{"name": "gmeter"}`,
+			body2: `{"name":"gmeter"}`,
+			want:  false,
+		},
+		{
+			name:  "formatting and variables order",
+			body1: `{"query": "query User($id: ID!) { user(id: $id) { name } }", "operationName": "User", "variables": {"id": 1, "locale": "en"}}`,
+			body2: `{"operationName":"User","variables":{"locale":"en","id":1},"query":"query User($id: ID!) {\n  user(id: $id) {\n    name\n  }\n}"}`,
+			want:  true,
+		},
+		{
+			name:  "different operation name",
+			body1: `{"query": "{ user { name } }", "operationName": "A"}`,
+			body2: `{"query": "{ user { name } }", "operationName": "B"}`,
+			want:  false,
+		},
+		{
+			name:  "different variables",
+			body1: `{"query": "{ user { name } }", "variables": {"id": 1}}`,
+			body2: `{"query": "{ user { name } }", "variables": {"id": 2}}`,
+			want:  false,
+		},
+		{
+			name:    "ignored variables",
+			graphQL: graphQL{IgnoreVariables: []string{"requestId", "input.clientMutationId"}},
+			body1:   `{"query": "mutation { save }", "variables": {"requestId": "a", "input": {"clientMutationId": "1", "name": "x"}}}`,
+			body2:   `{"query": "mutation { save }", "variables": {"requestId": "b", "input": {"clientMutationId": "2", "name": "x"}}}`,
+			want:    true,
+		},
+		{
+			name:  "batch",
+			body1: `[{"query": "{ a }"}, {"query": "{ b }", "variables": {"x": 1, "y": 2}}]`,
+			body2: `[{"query":"{a}"},{"query":"{b}","variables":{"y":2,"x":1}}]`,
+			want:  true,
+		},
+		{
+			name:  "large numbers are preserved",
+			body1: `{"query": "{ a }", "variables": {"id": 9007199254740993}}`,
+			body2: `{"query": "{ a }", "variables": {"id": 9007199254740992}}`,
+			want:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got1 := tt.graphQL.filter(http.Header{}, []byte(tt.body1))
+			_, got2 := tt.graphQL.filter(http.Header{}, []byte(tt.body2))
+
+			if got := string(*got1) == string(*got2); got != tt.want {
+				t.Errorf("graphQL.filter got: %s and %s, want equal: %t", *got1, *got2, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_GraphQL(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"user": {"name": "gmeter"}}}`))
+	}))
+	defer target.Close()
+
+	query := func(rt *RoundTripper, body string) (*http.Response, error) {
+		r := httptest.NewRequest("POST", target.URL+"/graphql", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+		return rt.RoundTrip(r)
+	}
+
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: Options{CassettePath: t.TempDir()}}
+	rt.Record(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmeter/record", strings.NewReader(`{"cassette": "graphql", "graphql": {}}`)))
+
+	resp, err := query(rt, `{"query": "{ user { name } }"}`)
+	if err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	rt.Play(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmeter/play", strings.NewReader(`{"cassette": "graphql", "graphql": {}}`)))
+
+	//the same query with a different formatting and Content-Length
+	resp, err = query(rt, `{
+		"query": "{\n  user {\n    name\n  }\n}"
+	}`)
+	if err != nil {
+		t.Fatalf("failed to play: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != `{"data": {"user": {"name": "gmeter"}}}` {
+		t.Errorf("unexpected played back body: %s", body)
+	}
+}
@@ -4,24 +4,63 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httputil"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sync"
+	"time"
 )
 
 // request is a recorded HTTP request.
 type request struct {
-	Method string
-	URL    *url.URL
-	Header http.Header
-	Body   []byte
+	Method   string
+	URL      *url.URL
+	Header   http.Header
+	Body     []byte
+	Messages []Message `json:",omitempty"`
+	Parts    []Part    `json:",omitempty"`
+}
+
+// body returns the recorded request body.
+func (r request) body() []byte {
+	if len(r.Messages) > 0 {
+		return encodeMessages(r.Messages)
+	}
+
+	return r.Body
+}
+
+// dump returns the recorded request in the HTTP/1.1 wire format.
+func (r request) dump() (string, error) {
+	req := &http.Request{
+		Method:     r.Method,
+		URL:        r.URL,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     r.Header,
+		Body:       toReadCloser(r.body()),
+	}
+
+	if r.URL != nil {
+		req.Host = r.URL.Host
+	} else {
+		req.URL = &url.URL{}
+	}
+
+	b, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to dump track request: %v", err)
+	}
+
+	return string(b), nil
 }
 
 // response is a recorded HTTP response.
@@ -34,24 +73,60 @@ type response struct {
 
 	Header           http.Header
 	Body             []byte
+	Chunks           []Chunk   `json:",omitempty"`
+	Events           []Event   `json:",omitempty"`
+	Frames           []Frame   `json:",omitempty"`
+	Messages         []Message `json:",omitempty"`
 	ContentLength    int64
 	TransferEncoding []string
 	Trailer          http.Header
 	TLS              *tls.ConnectionState
 }
 
+// Timing is the recorded latency of the live server.
+type Timing struct {
+	// FirstByte is the time it took to receive the response header.
+	FirstByte time.Duration
+
+	// Total is the time it took to receive the complete response.
+	Total time.Duration
+}
+
 // track is a recording (HTTP request + response) in a cassette.
 type track struct {
 	Request  request
 	Response response
 	ErrType  string
 	ErrMsg   string
+	Timing   Timing
+
+	// Scenario is the name of the state machine the track belongs to. The tracks of a scenario
+	// are not used up by the playback: they match only when the scenario is in the RequiredState
+	// (or in any state if it's empty) and move the scenario to the NewState (if it's set).
+	Scenario      string `json:",omitempty"`
+	RequiredState string `json:",omitempty"`
+	NewState      string `json:",omitempty"`
 
 	// replayed indicates whether the track has already been processed in the cassette playback.
 	replayed bool
 }
 
-func (t *track) response(req *http.Request) *http.Response {
+// TrackError is the error that was recorded on a track instead of a response.
+// It is returned when such a track is played back.
+type TrackError struct {
+	// Type is the Go type of the original error, e.g. *net.OpError.
+	Type string
+
+	// Msg is the message of the original error.
+	Msg string
+}
+
+// Error is an implementation of error.
+func (e *TrackError) Error() string {
+	return e.Type + ": " + e.Msg
+}
+
+func (t *track) response(req *http.Request) (*http.Response, error) {
 	var (
 		err  error
 		resp = &http.Response{}
@@ -68,20 +143,20 @@ func (t *track) response(req *http.Request) *http.Response {
 			Net:    "govcr",
 			Source: nil,
 			Addr:   nil,
-			Err:    errors.New(t.ErrType + ": " + t.ErrMsg),
+			Err:    &TrackError{Type: t.ErrType, Msg: t.ErrMsg},
 		}
 	case "":
 		err = nil
 
 	default:
-		err = errors.New(t.ErrType + ": " + t.ErrMsg)
+		err = &TrackError{Type: t.ErrType, Msg: t.ErrMsg}
 	}
 
 	if err != nil {
 		// No need to parse the response.
-		// By convention, when an HTTP error occurred, the response should be empty
+		// By convention, when an HTTP error occurred, the response should be nil
 		// (or Go's http package will show a warning message at runtime).
-		return resp
+		return nil, err
 	}
 
 	// re-create the response object from track record
@@ -104,14 +179,18 @@ func (t *track) response(req *http.Request) *http.Response {
 
 	resp.TLS = tls
 
-	return resp
+	return resp, nil
 }
 
 // newTrack creates a new track from an HTTP request and response.
-func newTrack(req *http.Request, resp *http.Response, reqErr error) (*track, error) {
+// start is the time when the request was sent to the live server.
+// The response body is not read: it is recorded by a recordingBody while
+// it's being read by the client.
+func newTrack(req *http.Request, resp *http.Response, reqErr error, start time.Time) (*track, error) {
 	var (
 		k7Request  request
 		k7Response response
+		elapsed    = time.Since(start)
 	)
 
 	// build request object
@@ -127,15 +206,24 @@ func newTrack(req *http.Request, resp *http.Response, reqErr error) (*track, err
 			Header: req.Header,
 			Body:   bodyData,
 		}
+
+		if isGRPC(req.Header) {
+			if messages, ok := parseMessages([]Chunk{{Data: bodyData}}); ok {
+				k7Request.Messages = messages
+				k7Request.Body = nil
+			}
+		}
+
+		if boundary := multipartBoundary(req.Header); boundary != "" {
+			if parts, err := parseParts(boundary, bodyData); err == nil && len(parts) > 0 {
+				k7Request.Parts = parts
+				k7Request.Body = nil
+			}
+		}
 	}
 
 	// build response object
 	if resp != nil {
-		bodyData, err := readResponseBody(resp)
-		if err != nil {
-			return nil, err
-		}
-
 		k7Response = response{
 			Status:     resp.Status,
 			StatusCode: resp.StatusCode,
@@ -144,7 +232,6 @@ func newTrack(req *http.Request, resp *http.Response, reqErr error) (*track, err
 			ProtoMinor: resp.ProtoMinor,
 
 			Header:           resp.Header,
-			Body:             bodyData,
 			ContentLength:    resp.ContentLength,
 			TransferEncoding: resp.TransferEncoding,
 			Trailer:          resp.Trailer,
@@ -164,6 +251,7 @@ func newTrack(req *http.Request, resp *http.Response, reqErr error) (*track, err
 		Response: k7Response,
 		ErrType:  reqErrType,
 		ErrMsg:   reqErrMsg,
+		Timing:   Timing{FirstByte: elapsed, Total: elapsed},
 	}
 
 	return track, nil
@@ -190,20 +278,36 @@ type cassette struct {
 
 	// stats is unexported since it doesn't need serialising
 	stats Stats
+
+	// mu guards Tracks and states since the streamed tracks are recorded
+	// concurrently with the other requests
+	mu sync.Mutex
+
+	// states holds the current state of the scenarios that left the ScenarioStarted state
+	states map[string]string
 }
 
-func (k7 *cassette) replayResponse(trackNumber int, req *http.Request) *http.Response {
+func (k7 *cassette) replayResponse(trackNumber int, req *http.Request) (*http.Response, error) {
 	if trackNumber == trackNotFound || trackNumber >= len(k7.Tracks) {
-		return nil
+		return nil, nil
 	}
 	track := &k7.Tracks[trackNumber]
 
-	// mark the track as replayed so it doesn't get re-used
+	// mark the track as replayed so it doesn't get re-used unless it belongs to a scenario
 	track.replayed = true
+	k7.transition(track)
 
 	return track.response(req)
 }
 
+// track returns a copy of the track, it's safe to use while new tracks are recorded.
+func (k7 *cassette) track(trackNumber int) track {
+	k7.mu.Lock()
+	defer k7.mu.Unlock()
+
+	return k7.Tracks[trackNumber]
+}
+
 // saveCassette writes a cassette to file.
 func (k7 *cassette) save() error {
 	// marshal
@@ -232,8 +336,39 @@ func (k7 *cassette) save() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(filename, iData.Bytes(), 0640)
-	return err
+	return writeFileAtomic(filename, iData.Bytes(), 0640)
+}
+
+// writeFileAtomic writes the data to a temporary file and renames it to filename
+// so that the file is never left truncated if the process is interrupted.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	// the temporary file is removed if it hasn't been renamed
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(f.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
 }
 
 // addTrack adds a track to a cassette.
@@ -243,6 +378,9 @@ func (k7 *cassette) addTrack(track *track) {
 
 // Stats returns the cassette's Stats.
 func (k7 *cassette) Stats() Stats {
+	k7.mu.Lock()
+	defer k7.mu.Unlock()
+
 	k7.stats.TracksRecorded = k7.numberOfTracks() - k7.stats.TracksLoaded
 	k7.stats.TracksPlayed = k7.tracksPlayed() - k7.stats.TracksRecorded
 
@@ -359,12 +497,9 @@ func readCassetteFromFile(cassetteName, cassettePath string) (*cassette, error)
 }
 
 // recordNewTrackToCassette saves a new track to a cassette.
-func recordNewTrackToCassette(cassette *cassette, req *http.Request, resp *http.Response, httpErr error) error {
-	// create track
-	track, err := newTrack(req, resp, httpErr)
-	if err != nil {
-		return err
-	}
+func recordNewTrackToCassette(cassette *cassette, track *track) error {
+	cassette.mu.Lock()
+	defer cassette.mu.Unlock()
 
 	// mark track as replayed since it's coming from a live request!
 	track.replayed = true
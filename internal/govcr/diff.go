@@ -0,0 +1,221 @@
+package govcr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// maxDiffValueLength limits the length of the values shown in the diffs.
+const maxDiffValueLength = 256
+
+// TrackDiff describes how a track on the cassette differs from the request.
+type TrackDiff struct {
+	// Position is the number of the track on the cassette starting from 0.
+	Position int `json:"position"`
+
+	// Score is the weighted share of the fields that match, 1 is a perfect match.
+	Score float64 `json:"score"`
+
+	// Diffs lists the fields that don't match.
+	Diffs []FieldDiff `json:"diffs"`
+}
+
+// FieldDiff is a field of the track that doesn't match the request.
+type FieldDiff struct {
+	// Field is one of: state, method, scheme, host, path, query, header <name>, body.
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// String returns the human readable diff.
+func (d TrackDiff) String() string {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "track #%d (score %.2f):\n", d.Position, d.Score)
+	for _, fd := range d.Diffs {
+		fmt.Fprintf(&buf, "  %s: expected %q, got %q\n", fd.Field, fd.Expected, fd.Actual)
+	}
+
+	return buf.String()
+}
+
+type closestTracksKey struct{}
+
+// closestTracksFunc computes the diffs of the n tracks that are closest to the request.
+type closestTracksFunc func(n int) []TrackDiff
+
+// ClosestTracks returns the diffs of the n tracks that are closest to the request that was
+// not found on the cassette. The context is the one of the request passed to the VCRConfig.Client
+// transport when the recording is disabled, otherwise it returns nil.
+func ClosestTracks(ctx context.Context, n int) []TrackDiff {
+	f, ok := ctx.Value(closestTracksKey{}).(closestTracksFunc)
+	if !ok {
+		return nil
+	}
+
+	return f(n)
+}
+
+// withClosestTracks makes the diagnostics of the request available to the transport.
+// The diffs are computed only if they're requested.
+func (t *vcrTransport) withClosestTracks(req, copiedReq *http.Request) *http.Request {
+	f := closestTracksFunc(func(n int) []TrackDiff {
+		t.Cassette.mu.Lock()
+		defer t.Cassette.mu.Unlock()
+
+		return t.PCB.closestTracks(t.Cassette, copiedReq, n)
+	})
+
+	return req.WithContext(context.WithValue(req.Context(), closestTracksKey{}, f))
+}
+
+// closestTracks scores every track on the cassette and returns the n best ones.
+// The caller must hold cassette.mu.
+func (pcbr *pcb) closestTracks(cassette *cassette, req *http.Request, n int) []TrackDiff {
+	body, err := readRequestBody(req)
+	if err != nil {
+		pcbr.Logger.Error("failed to read request body", "error", err)
+		return nil
+	}
+
+	diffs := make([]TrackDiff, 0, len(cassette.Tracks))
+	for i := range cassette.Tracks {
+		diffs = append(diffs, pcbr.diffTrack(cassette, i, req, body))
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Score > diffs[j].Score
+	})
+
+	if len(diffs) > n {
+		diffs = diffs[:n]
+	}
+
+	return diffs
+}
+
+// diffTrack compares the track with the request the same way trackMatches does.
+// The method and the path weigh more than the other fields since they
+// identify the endpoint.
+func (pcbr *pcb) diffTrack(cassette *cassette, trackNumber int, req *http.Request, body []byte) TrackDiff {
+	track := cassette.Tracks[trackNumber]
+	d := TrackDiff{Position: trackNumber}
+
+	var total, matched float64
+	compare := func(field, expected, actual string, weight float64) {
+		total += weight
+		if expected == actual {
+			matched += weight
+			return
+		}
+
+		d.Diffs = append(d.Diffs, FieldDiff{Field: field, Expected: truncateDiffValue(expected), Actual: truncateDiffValue(actual)})
+	}
+
+	if track.replayed && track.Scenario == "" {
+		d.Diffs = append(d.Diffs, FieldDiff{Field: "state", Expected: "not played", Actual: "already played"})
+	}
+
+	if !cassette.inState(&track) {
+		d.Diffs = append(d.Diffs, FieldDiff{
+			Field:    "state",
+			Expected: "scenario " + track.Scenario + " in state " + track.RequiredState,
+			Actual:   "scenario " + track.Scenario + " in state " + cassette.scenarioState(track.Scenario),
+		})
+	}
+
+	trackURL := track.Request.URL
+	if trackURL == nil {
+		trackURL = req.URL
+	}
+
+	compare("method", track.Request.Method, req.Method, 3)
+	compare("scheme", trackURL.Scheme, req.URL.Scheme, 1)
+	compare("host", trackURL.Host, req.URL.Host, 1)
+	compare("path", trackURL.Path, req.URL.Path, 3)
+	compare("query", trackURL.RawQuery, req.URL.RawQuery, 2)
+
+	trackHeader, trackBody := pcbr.RequestFilterFunc(track.Request.Header, track.Request.body())
+	reqHeader, reqBody := pcbr.RequestFilterFunc(req.Header, body)
+
+	if len(track.Request.Parts) > 0 {
+		*trackHeader, *reqHeader = withoutBoundary(*trackHeader), withoutBoundary(*reqHeader)
+	}
+
+	for _, k := range headerKeys(*trackHeader, *reqHeader) {
+		if isVolatileHeader(k) || pcbr.ExcludeHeaderFunc(k) {
+			continue
+		}
+
+		compare("header "+k, strings.Join((*trackHeader)[k], ", "), strings.Join((*reqHeader)[k], ", "), 1)
+	}
+
+	if len(track.Request.Parts) > 0 {
+		total += 2
+		if partsResemble(track.Request.Parts, req.Header, *reqBody) {
+			matched += 2
+		} else {
+			d.Diffs = append(d.Diffs, FieldDiff{Field: "body", Expected: describeParts(track.Request.Parts), Actual: describeRequestParts(req.Header, *reqBody)})
+		}
+	} else {
+		compare("body", string(*trackBody), string(*reqBody), 2)
+	}
+
+	d.Score = matched / total
+	if len(d.Diffs) > 0 && d.Score == 1 {
+		// the track doesn't match because of its state
+		d.Score = 0.99
+	}
+
+	return d
+}
+
+// headerKeys returns the sorted union of the header keys.
+func headerKeys(header1, header2 http.Header) []string {
+	seen := map[string]bool{}
+	var keys []string
+
+	for _, h := range []http.Header{header1, header2} {
+		for k := range h {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// describeParts returns a short description of the multipart body.
+func describeParts(parts []Part) string {
+	descriptions := make([]string, 0, len(parts))
+	for _, p := range parts {
+		descriptions = append(descriptions, fmt.Sprintf("%s(%s) sha256:%.12s", p.Name, p.Filename, p.SHA256))
+	}
+
+	return strings.Join(descriptions, ", ")
+}
+
+func describeRequestParts(header http.Header, body []byte) string {
+	parts, err := parseParts(multipartBoundary(header), body)
+	if err != nil {
+		return string(body)
+	}
+
+	return describeParts(parts)
+}
+
+func truncateDiffValue(s string) string {
+	if len(s) <= maxDiffValueLength {
+		return s
+	}
+
+	return s[:maxDiffValueLength] + "..."
+}
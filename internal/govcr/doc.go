@@ -0,0 +1,11 @@
+/*
+Package govcr records and replays HTTP interactions for offline unit / behavioural / integration tests thereby acting as an HTTP mock.
+
+This project was inspired by php-vcr which is a PHP port of VCR for ruby.
+
+This package is a fork of https://github.com/seborama/govcr (v1) that is owned and
+maintained as a part of gmeter. It's distributed under the original Apache 2.0 license
+(see LICENSE), the upstream documentation is kept in README.md. The fork is changed to
+the needs of gmeter and must not be replaced with the upstream package.
+*/
+package govcr
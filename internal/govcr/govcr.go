@@ -0,0 +1,835 @@
+package govcr
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VCRControlPanel holds the parts of a VCR that can be interacted with.
+// Client is the HTTP client associated with the VCR.
+type VCRControlPanel struct {
+	Client *http.Client
+}
+
+// Stats returns Stats about the cassette and VCR session.
+func (vcr *VCRControlPanel) Stats() Stats {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+	return vcrT.Cassette.Stats()
+}
+
+// Flush closes the WebSocket connections that are being recorded so that their
+// tracks are saved and writes the cassette to file if new tracks were recorded
+// in the session. The other tracks are saved as soon as they are recorded, Flush
+// is meant to be called on shutdown after the in-flight requests are done.
+func (vcr *VCRControlPanel) Flush() error {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+	if vcrT.PCB.DisableRecording {
+		return nil
+	}
+
+	// the hijacked connections are not waited for by the HTTP server on shutdown
+	for _, conn := range vcrT.openConns() {
+		conn.Close()
+	}
+
+	k7 := vcrT.Cassette
+
+	k7.mu.Lock()
+	defer k7.mu.Unlock()
+
+	if k7.numberOfTracks() == k7.stats.TracksLoaded {
+		return nil
+	}
+
+	return k7.save()
+}
+
+const defaultCassettePath = "./govcr-fixtures/"
+
+// VCRConfig holds a set of options for the VCR.
+type VCRConfig struct {
+	Client            *http.Client
+	ExcludeHeaderFunc ExcludeHeaderFunc
+	RequestFilterFunc RequestFilterFunc
+
+	// ResponseFilterFunc can be used to modify the header of the response.
+	// This is useful when a fingerprint is exchanged and expected to match between request and response.
+	ResponseFilterFunc ResponseFilterFunc
+
+	// LatencyFunc can be used to delay the played back responses.
+	LatencyFunc LatencyFunc
+
+	// ChunkDelayFunc can be used to change the pace of the played back streamed responses.
+	ChunkDelayFunc ChunkDelayFunc
+
+	// RecordingErrorFunc is called when a track can't be recorded,
+	// e.g. when the cassette can't be written to the file.
+	RecordingErrorFunc RecordingErrorFunc
+
+	DisableRecording bool
+
+	// Logging enables the default logger that writes to stderr, it's ignored if Logger is set.
+	Logging bool
+
+	// Logger is used instead of the default logger, the VCR logs
+	// the matched tracks at the debug level and the failures at the error level.
+	Logger *slog.Logger
+
+	CassettePath string
+
+	// Sequential makes the VCR play back the tracks in the recorded order: every request
+	// must match the next track that hasn't been played yet, otherwise a SequenceError
+	// is returned. It's meant to be used along with DisableRecording.
+	Sequential bool
+}
+
+// PCB stands for Printed Circuit Board. It is a structure that holds some
+// facilities that are passed to the VCR machine to modify its internals.
+type pcb struct {
+	Transport          http.RoundTripper
+	ExcludeHeaderFunc  ExcludeHeaderFunc
+	RequestFilterFunc  RequestFilterFunc
+	ResponseFilterFunc ResponseFilterFunc
+	LatencyFunc        LatencyFunc
+	ChunkDelayFunc     ChunkDelayFunc
+	RecordingErrorFunc RecordingErrorFunc
+	Logger             *slog.Logger
+	DisableRecording   bool
+	CassettePath       string
+	Sequential         bool
+}
+
+const trackNotFound = -1
+
+func (pcbr *pcb) seekTrack(cassette *cassette, req *http.Request) int {
+	for idx := range cassette.Tracks {
+		if pcbr.trackMatches(cassette, idx, req) {
+			pcbr.Logger.Debug("found a matching track", "cassette", cassette.Name, "method", req.Method, "url", req.URL.String(), "track", idx)
+			return idx
+		}
+	}
+
+	return trackNotFound
+}
+
+// Matches checks whether the track is a match for the supplied request.
+func (pcbr *pcb) trackMatches(cassette *cassette, trackNumber int, req *http.Request) bool {
+	if req == nil {
+		return false
+	}
+
+	// get body data safely
+	bodyData, err := readRequestBody(req)
+	if err != nil {
+		pcbr.Logger.Error("failed to read request body", "error", err)
+		return false
+	}
+
+	track := cassette.Tracks[trackNumber]
+
+	// apply filter function to track header / body
+	filteredTrackHeader, filteredTrackBody := pcbr.RequestFilterFunc(track.Request.Header, track.Request.body())
+	// apply filter function to request header / body
+	filteredReqHeader, filteredReqBody := pcbr.RequestFilterFunc(req.Header, bodyData)
+
+	if (track.replayed && track.Scenario == "") ||
+		!cassette.inState(&track) ||
+		track.Request.Method != req.Method ||
+		track.Request.URL.String() != req.URL.String() {
+		return false
+	}
+
+	// multipart bodies are matched by their parts since the boundary is random
+	if len(track.Request.Parts) > 0 {
+		return pcbr.headerResembles(withoutBoundary(*filteredTrackHeader), withoutBoundary(*filteredReqHeader)) &&
+			partsResemble(track.Request.Parts, req.Header, *filteredReqBody)
+	}
+
+	return pcbr.headerResembles(*filteredTrackHeader, *filteredReqHeader) &&
+		pcbr.bodyResembles(*filteredTrackBody, *filteredReqBody)
+}
+
+// headerResembles compares HTTP headers for equivalence.
+func (pcbr *pcb) headerResembles(header1 http.Header, header2 http.Header) bool {
+	for k := range header1 {
+		if isVolatileHeader(k) {
+			continue
+		}
+
+		// TODO: a given header may have several values (and in any order)
+		if GetFirstValue(header1, k) != GetFirstValue(header2, k) && !pcbr.ExcludeHeaderFunc(k) {
+			return false
+		}
+	}
+
+	// finally assert the number of headers match
+	// TODO: perhaps should count how many pcb.ExcludeHeaderFunc() returned true and remove that count from the len to compare?
+	return len(header1) == len(header2)
+}
+
+// isVolatileHeader checks whether the value of the header is expected to change
+// on every request: the WebSocket key is a random nonce generated by the client
+// for every handshake and the gRPC timeout is the time left until the deadline.
+func isVolatileHeader(key string) bool {
+	return strings.EqualFold(key, "Sec-WebSocket-Key") || strings.EqualFold(key, "Grpc-Timeout")
+}
+
+// bodyResembles compares HTTP bodies for equivalence.
+func (pcbr *pcb) bodyResembles(body1 []byte, body2 []byte) bool {
+	return bytes.Compare(body1, body2) == 0
+}
+
+func (pcbr *pcb) filterResponse(resp *http.Response, req *http.Request) *http.Response {
+	body, err := readResponseBody(resp)
+	if err != nil {
+		pcbr.Logger.Error("unable to filter response body so leaving it untouched", "error", err)
+		return resp
+	}
+
+	newHeader, newBody := pcbr.ResponseFilterFunc(resp.Header, body, req)
+	resp.Header = *newHeader
+	resp.Body = toReadCloser(*newBody)
+
+	// the filter may change the length of the body
+	if len(*newBody) != len(body) {
+		resp.ContentLength = int64(len(*newBody))
+		if resp.Header.Get("Content-Length") != "" {
+			resp.Header = cloneHeader(resp.Header)
+			resp.Header.Set("Content-Length", strconv.Itoa(len(*newBody)))
+		}
+	}
+
+	return resp
+}
+
+// filterChunks applies the ResponseFilterFunc to the data of each chunk of the played back
+// streamed response so that the pace of the stream is kept. The header is filtered once,
+// along with the first chunk.
+func (pcbr *pcb) filterChunks(resp *http.Response, chunks []Chunk, req *http.Request) []Chunk {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		pcbr.Logger.Error("unable to filter response chunks so leaving them untouched", "error", err)
+		return chunks
+	}
+
+	header := resp.Header
+	filtered := make([]Chunk, len(chunks))
+	for i, chunk := range chunks {
+		// the filter may consume the request body
+		if reqBody != nil {
+			req.Body = toReadCloser(reqBody)
+		}
+
+		newHeader, newData := pcbr.ResponseFilterFunc(header, chunk.Data, req)
+		if i == 0 {
+			resp.Header = *newHeader
+		}
+
+		filtered[i] = Chunk{Delay: chunk.Delay, Data: *newData}
+	}
+
+	return filtered
+}
+
+// withoutLength makes the length of the played back response unknown, since the events and
+// the messages can be edited on the cassette and the recorded length may no longer match them.
+func withoutLength(resp *http.Response) {
+	resp.ContentLength = -1
+	if resp.Header.Get("Content-Length") != "" {
+		resp.Header = cloneHeader(resp.Header)
+		resp.Header.Del("Content-Length")
+	}
+}
+
+// GetFirstValue is a utility function that extracts the first value of a header key.
+// The reason for this function is that some servers require case sensitive headers which
+// prevent the use of http.Header.Get() as it expects header keys to be canonicalized.
+func GetFirstValue(hdr http.Header, key string) string {
+	for k, val := range hdr {
+		if strings.ToLower(k) == strings.ToLower(key) {
+			if len(val) > 0 {
+				return val[0]
+			}
+			return ""
+		}
+	}
+
+	return ""
+}
+
+// NewVCR creates a new VCR and loads a cassette.
+// A RoundTripper can be provided when a custom Transport is needed (for example to provide
+// certificates, etc)
+func NewVCR(cassetteName string, vcrConfig *VCRConfig) *VCRControlPanel {
+	if vcrConfig == nil {
+		vcrConfig = &VCRConfig{}
+	}
+
+	// set up logging
+	logger := vcrConfig.Logger
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+		if vcrConfig.Logging {
+			logger = slog.New(slog.NewTextHandler(os.Stderr, nil))
+		}
+	}
+
+	// use a default client if none provided
+	if vcrConfig.Client == nil {
+		vcrConfig.Client = http.DefaultClient
+	}
+
+	// use a default transport if none provided
+	if vcrConfig.Client.Transport == nil {
+		vcrConfig.Client.Transport = http.DefaultTransport
+	}
+
+	// use a default set of FilterFunc's
+	if vcrConfig.ExcludeHeaderFunc == nil {
+		vcrConfig.ExcludeHeaderFunc = func(key string) bool {
+			return false
+		}
+	}
+
+	if vcrConfig.RequestFilterFunc == nil {
+		vcrConfig.RequestFilterFunc = func(header http.Header, body []byte) (*http.Header, *[]byte) {
+			return &header, &body
+		}
+	}
+
+	if vcrConfig.ResponseFilterFunc == nil {
+		vcrConfig.ResponseFilterFunc = func(respHdr http.Header, body []byte, req *http.Request) (*http.Header, *[]byte) {
+			return &respHdr, &body
+		}
+	}
+
+	if vcrConfig.LatencyFunc == nil {
+		vcrConfig.LatencyFunc = func(Timing) Timing {
+			return Timing{}
+		}
+	}
+
+	if vcrConfig.ChunkDelayFunc == nil {
+		vcrConfig.ChunkDelayFunc = func(time.Duration) time.Duration {
+			return 0
+		}
+	}
+
+	if vcrConfig.RecordingErrorFunc == nil {
+		vcrConfig.RecordingErrorFunc = func(error) {}
+	}
+
+	// load cassette
+	cassette, err := loadCassette(cassetteName, vcrConfig.CassettePath)
+	if err != nil {
+		logger.Error("failed to load cassette", "cassette", cassetteName, "error", err)
+		os.Exit(1)
+	}
+
+	// create PCB
+	pcbr := &pcb{
+		// TODO: create appropriate test!
+		DisableRecording:   vcrConfig.DisableRecording,
+		Transport:          vcrConfig.Client.Transport,
+		ExcludeHeaderFunc:  vcrConfig.ExcludeHeaderFunc,
+		RequestFilterFunc:  vcrConfig.RequestFilterFunc,
+		ResponseFilterFunc: vcrConfig.ResponseFilterFunc,
+		LatencyFunc:        vcrConfig.LatencyFunc,
+		ChunkDelayFunc:     vcrConfig.ChunkDelayFunc,
+		RecordingErrorFunc: vcrConfig.RecordingErrorFunc,
+		Logger:             logger,
+		CassettePath:       vcrConfig.CassettePath,
+		Sequential:         vcrConfig.Sequential,
+	}
+
+	// create VCR's HTTP client
+	vcrClient := &http.Client{
+		Transport: &vcrTransport{
+			PCB:      pcbr,
+			Cassette: cassette,
+		},
+	}
+
+	// copy the attributes of the original http.Client
+	vcrClient.CheckRedirect = vcrConfig.Client.CheckRedirect
+	vcrClient.Jar = vcrConfig.Client.Jar
+	vcrClient.Timeout = vcrConfig.Client.Timeout
+
+	// return
+	return &VCRControlPanel{
+		Client: vcrClient,
+	}
+}
+
+// ExcludeHeaderFunc is a hook function that is used to filter the Header.
+//
+// Typically this can be used to remove / amend undesirable custom headers from the request.
+//
+// For instance, if your application sends requests with a timestamp held in a custom header,
+// you likely want to exclude it from the comparison to ensure that the request headers are
+// considered a match with those saved on the cassette's track.
+//
+// Parameters:
+//  - parameter 1 - Name of header key in the Request
+//
+// Return value:
+//  true - retain header key for comparison
+// false - ignore header key for comparison
+type ExcludeHeaderFunc func(key string) bool
+
+// RequestFilterFunc is a hook function that is used to filter the Request Header / Body.
+//
+// Typically this can be used to remove / amend undesirable header / body elements from the request.
+//
+// For instance, if your application sends requests with a timestamp held in a part of
+// the header / body, you likely want to remove it or force a static timestamp via
+// RequestFilterFunc to ensure that the request body matches those saved on the cassette's track.
+//
+// It is important to note that this differs from ExcludeHeaderFunc in that the former does not
+// modify the header (it only returns a bool) whereas this function can be used to modify the header.
+//
+// Parameters:
+//  - parameter 1 - Copy of http.Header of the Request
+//  - parameter 2 - Copy of string of the Request's Body
+//
+// Return values:
+//  - value 1 - Request's amended header
+//  - value 1 - Request's amended body
+type RequestFilterFunc func(http.Header, []byte) (*http.Header, *[]byte)
+
+// ResponseFilterFunc is a hook function that is used to filter the Response Header / Body.
+//
+// It works similarly to RequestFilterFunc but applies to the Response and also receives a
+// copy of the Request (if you need to pick info from it to override the response).
+// The Response's header is shared with the cassette's track and must not be modified in place.
+//
+// Parameters:
+//  - parameter 1 - http.Header of the Response
+//  - parameter 2 - Copy of string of the Response's Body
+//  - parameter 3 - Copy of the Request, its Body can be read
+//
+// Return values:
+//  - value 1 - Response's amended header
+//  - value 1 - Response's amended body
+type ResponseFilterFunc func(http.Header, []byte, *http.Request) (*http.Header, *[]byte)
+
+// LatencyFunc is a hook function that is used to simulate the latency of the live server.
+//
+// Parameters:
+//  - parameter 1 - Timing recorded on the cassette's track
+//
+// Return value:
+//  - Timing to apply to the played back response: the response is returned after
+//    FirstByte and its Body reaches EOF after Total
+type LatencyFunc func(Timing) Timing
+
+// ChunkDelayFunc is a hook function that is used to pace the chunks of the played back
+// streamed responses. By default chunks are played back without delays.
+//
+// Parameters:
+//  - parameter 1 - recorded delay between the chunk and the previous one
+//
+// Return value:
+//  - delay to apply to the played back chunk
+type ChunkDelayFunc func(time.Duration) time.Duration
+
+// RecordingErrorFunc is a hook function that is called when a track can't be recorded.
+//
+// Parameters:
+//  - parameter 1 - the error, it's also written to the log
+type RecordingErrorFunc func(error)
+
+// vcrTransport is the heart of VCR. It provides
+// an http.RoundTripper that wraps over the default
+// one provided by Go's http package or a custom one
+// if specified when calling NewVCR.
+type vcrTransport struct {
+	PCB      *pcb
+	Cassette *cassette
+
+	// conns are the WebSocket connections that are being recorded
+	connsMu sync.Mutex
+	conns   map[*recordingConn]struct{}
+}
+
+// addConn adds the connection to the open connections.
+func (t *vcrTransport) addConn(conn *recordingConn) {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+
+	if t.conns == nil {
+		t.conns = map[*recordingConn]struct{}{}
+	}
+
+	t.conns[conn] = struct{}{}
+}
+
+// removeConn removes the closed connection from the open connections.
+func (t *vcrTransport) removeConn(conn *recordingConn) {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+
+	delete(t.conns, conn)
+}
+
+// openConns returns the WebSocket connections that are being recorded.
+func (t *vcrTransport) openConns() []*recordingConn {
+	t.connsMu.Lock()
+	defer t.connsMu.Unlock()
+
+	conns := make([]*recordingConn, 0, len(t.conns))
+	for conn := range t.conns {
+		conns = append(conns, conn)
+	}
+
+	return conns
+}
+
+// RoundTrip is an implementation of http.RoundTripper.
+func (t *vcrTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		// Note: by convention resp should be nil if an error occurs with HTTP
+		resp *http.Response
+
+		requestMatched bool
+		copiedReq      *http.Request
+	)
+
+	// copy the request before the body is closed by the HTTP server.
+	copiedReq, err := copyRequest(req)
+	if err != nil {
+		t.PCB.Logger.Error("failed to copy request", "error", err)
+		return nil, err
+	}
+
+	// attempt to use a track from the cassette that matches
+	// the request if one exists.
+	t.Cassette.mu.Lock()
+	trackNumber, err := t.PCB.findTrack(t.Cassette, copiedReq)
+	if err != nil {
+		t.Cassette.mu.Unlock()
+		setOutcome(req.Context(), ResultMissed, trackNotFound)
+		return nil, err
+	}
+
+	if trackNumber != trackNotFound {
+		requestMatched = true
+		resp, err = t.Cassette.replayResponse(trackNumber, copiedReq)
+		setOutcome(req.Context(), ResultPlayed, trackNumber)
+	}
+	t.Cassette.mu.Unlock()
+
+	if requestMatched {
+		track := t.Cassette.track(trackNumber)
+
+		timing := t.PCB.LatencyFunc(track.Timing)
+		if err := delay(req.Context(), timing.FirstByte); err != nil {
+			return nil, err
+		}
+
+		if err != nil {
+			// the live server failed when the track was recorded
+			return nil, err
+		}
+
+		if isWebSocketUpgrade(resp) {
+			resp.Header = cloneHeader(resp.Header)
+			resp.Header.Set("Sec-WebSocket-Accept", websocketAccept(req.Header.Get("Sec-WebSocket-Key")))
+			resp.Body = newReplayConn(req.Context(), track.Response.Frames, t.PCB.ChunkDelayFunc)
+			return resp, nil
+		}
+
+		// SSE and gRPC streams are not filtered since the filter could break their framing
+		if len(track.Response.Events) > 0 {
+			withoutLength(resp)
+			resp.Body = &chunkedBody{ctx: req.Context(), chunks: eventsToChunks(track.Response.Events), delayFunc: t.PCB.ChunkDelayFunc}
+			return resp, nil
+		}
+
+		if len(track.Response.Messages) > 0 {
+			withoutLength(resp)
+			resp.Body = &chunkedBody{ctx: req.Context(), chunks: messagesToChunks(track.Response.Messages), delayFunc: t.PCB.ChunkDelayFunc}
+			return resp, nil
+		}
+
+		if len(track.Response.Chunks) > 0 {
+			chunks := t.PCB.filterChunks(resp, track.Response.Chunks, copiedReq)
+			resp.Body = &chunkedBody{ctx: req.Context(), chunks: chunks, delayFunc: t.PCB.ChunkDelayFunc}
+			return resp, nil
+		}
+
+		// only the played back response is filtered. Never the live response!
+		resp = t.PCB.filterResponse(resp, copiedReq)
+
+		if resp.Body != nil && timing.Total > timing.FirstByte {
+			resp.Body = &delayedReadCloser{ReadCloser: resp.Body, ctx: req.Context(), delay: timing.Total - timing.FirstByte}
+		}
+	}
+
+	if !requestMatched {
+		// no recorded track was found so execute the request live
+		t.PCB.Logger.Debug("executing request to live server", "cassette", t.Cassette.Name, "method", req.Method, "url", req.URL.String())
+
+		// in read-only mode the transport may want to know why the request was not found
+		if t.PCB.DisableRecording {
+			setOutcome(req.Context(), ResultMissed, trackNotFound)
+			req = t.withClosestTracks(req, copiedReq)
+		} else {
+			setOutcome(req.Context(), ResultRecorded, trackNotFound)
+		}
+
+		start := time.Now()
+		resp, err = t.PCB.Transport.RoundTrip(req)
+
+		if !t.PCB.DisableRecording {
+			// the VCR is not in read-only mode so
+			// record the HTTP traffic into a new track on the cassette
+			t.PCB.Logger.Debug("recording new track", "cassette", t.Cassette.Name, "method", req.Method, "url", req.URL.String())
+			t.recordTrack(copiedReq, resp, err, start)
+		}
+	}
+
+	return resp, err
+}
+
+// recordTrack records the live HTTP traffic into a new track on the cassette.
+// The response body is recorded while it's being read by the client so
+// large and streamed responses are not held back until they are complete.
+func (t *vcrTransport) recordTrack(req *http.Request, resp *http.Response, respErr error, start time.Time) {
+	track, err := newTrack(req, resp, respErr, start)
+	if err != nil {
+		t.recordingFailed(err)
+		return
+	}
+
+	if resp == nil || resp.Body == nil {
+		if err := recordNewTrackToCassette(t.Cassette, track); err != nil {
+			t.recordingFailed(err)
+		}
+		return
+	}
+
+	if conn, ok := resp.Body.(io.ReadWriteCloser); ok && isWebSocketUpgrade(resp) {
+		var rc *recordingConn
+		rc = newRecordingConn(conn, func(frames []Frame) {
+			t.removeConn(rc)
+
+			track.Response.Frames = frames
+			track.Timing.Total = time.Since(start)
+
+			if err := recordNewTrackToCassette(t.Cassette, track); err != nil {
+				t.recordingFailed(err)
+			}
+		})
+
+		t.addConn(rc)
+		resp.Body = rc
+		return
+	}
+
+	resp.Body = newRecordingBody(resp, func(body []byte, chunks []Chunk) {
+		track.Response.Body = body
+		track.Response.Chunks = chunks
+
+		if isEventStream(resp.Header) {
+			// events are stored instead of the raw body so they can be edited on the cassette
+			if chunks == nil {
+				chunks = []Chunk{{Data: body}}
+			}
+
+			track.Response.Events = parseEvents(chunks)
+			track.Response.Body = nil
+			track.Response.Chunks = nil
+		}
+
+		if isGRPC(resp.Header) {
+			if chunks == nil {
+				chunks = []Chunk{{Data: body}}
+			}
+
+			// messages are stored instead of the raw body unless the stream was cut in the middle of a message
+			if messages, ok := parseMessages(chunks); ok {
+				track.Response.Messages = messages
+				track.Response.Body = nil
+				track.Response.Chunks = nil
+			}
+		}
+
+		// trailers are only known once the body is read
+		track.Response.Trailer = resp.Trailer
+		track.Timing.Total = time.Since(start)
+
+		if err := recordNewTrackToCassette(t.Cassette, track); err != nil {
+			t.recordingFailed(err)
+		}
+	})
+}
+
+// recordingFailed reports the error of recording a track.
+func (t *vcrTransport) recordingFailed(err error) {
+	t.PCB.Logger.Error("failed to record track", "cassette", t.Cassette.Name, "error", err)
+	t.PCB.RecordingErrorFunc(err)
+}
+
+// copyRequest makes a copy an HTTP request.
+// It ensures that the original request Body stream is restored to its original state
+// and can be read from again.
+// TODO: should perform a deep copy of the TLS property as with URL
+func copyRequest(req *http.Request) (*http.Request, error) {
+	if req == nil {
+		return nil, nil
+	}
+
+	// get a deep copy without body considerations
+	copiedReq := copyRequestWithoutBody(req)
+
+	// deal with the Body
+	bodyCopy, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// restore Body stream state
+	req.Body = toReadCloser(bodyCopy)
+	copiedReq.Body = toReadCloser(bodyCopy)
+
+	return copiedReq, nil
+}
+
+// copyRequestWithoutBody makes a copy an HTTP request but not the Body (set to nil).
+// TODO: should perform a deep copy of the TLS property as with URL
+func copyRequestWithoutBody(req *http.Request) *http.Request {
+	if req == nil {
+		return nil
+	}
+
+	// get a shallow copy
+	copiedReq := *req
+
+	// remove the channel reference
+	copiedReq.Cancel = nil
+
+	// deal with the body
+	copiedReq.Body = nil
+
+	// deal with the URL (BEWARE obj == &*obj in Go, with obj being a pointer)
+	if req.URL != nil {
+		url := *req.URL
+		if req.URL.User != nil {
+			userInfo := *req.URL.User
+			url.User = &userInfo
+		}
+		copiedReq.URL = &url
+	}
+
+	return &copiedReq
+}
+
+// readRequestBody reads the Body data stream and restores its states.
+// It ensures the stream is restored to its original state and can be read from again.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req == nil || req.Body == nil {
+		return nil, nil
+	}
+
+	// dump the data
+	bodyWriter := bytes.NewBuffer(nil)
+
+	_, err := io.Copy(bodyWriter, req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	bodyData := bodyWriter.Bytes()
+
+	// restore original state of the Body source stream
+	req.Body.Close()
+	req.Body = toReadCloser(bodyData)
+
+	return bodyData, nil
+}
+
+// readResponseBody reads the Body data stream and restores its states.
+// It ensures the stream is restored to its original state and can be read from again.
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	if resp == nil || resp.Body == nil {
+		return nil, nil
+	}
+
+	// dump the data
+	bodyWriter := bytes.NewBuffer(nil)
+
+	_, err := io.Copy(bodyWriter, resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	bodyData := bodyWriter.Bytes()
+
+	// restore original state of the Body source stream
+	resp.Body = toReadCloser(bodyData)
+
+	return bodyData, nil
+}
+
+// cloneHeader makes a deep copy of the header so the header of the track is not modified.
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for k, v := range header {
+		clone[k] = append([]string(nil), v...)
+	}
+
+	return clone
+}
+
+func toReadCloser(body []byte) io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader(body))
+}
+
+// delay waits for the given duration or until the context is done.
+func delay(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delayedReadCloser postpones the first Read of the underlying ReadCloser.
+type delayedReadCloser struct {
+	io.ReadCloser
+
+	ctx     context.Context
+	delay   time.Duration
+	delayed bool
+}
+
+// Read is an implementation of io.Reader.
+func (d *delayedReadCloser) Read(p []byte) (int, error) {
+	if !d.delayed {
+		d.delayed = true
+		if err := delay(d.ctx, d.delay); err != nil {
+			return 0, err
+		}
+	}
+
+	return d.ReadCloser.Read(p)
+}
@@ -0,0 +1,70 @@
+package govcr
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestVCRControlPanel_Flush(t *testing.T) {
+	server := newWebSocketServer(t)
+	dir := t.TempDir()
+
+	vcr := NewVCR("ws", &VCRConfig{CassettePath: dir})
+
+	conn, r := dialWebSocket(t, vcr.Client, server.URL)
+	if f := readFrame(t, r); string(f.Payload) != "hello" {
+		t.Fatalf("unexpected greeting: %q", f.Payload)
+	}
+
+	conn.Write(clientFrame(0x1, "ping"))
+	if f := readFrame(t, r); string(f.Payload) != "ping" {
+		t.Fatalf("unexpected echo: %q", f.Payload)
+	}
+
+	// the connection is still open, its track is saved on flush
+	if err := vcr.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	if _, err := conn.Write(clientFrame(0x1, "late")); err == nil {
+		t.Errorf("connection is not closed on flush")
+	}
+
+	k7, err := readCassetteFromFile("ws", dir)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %v", err)
+	}
+
+	if len(k7.Tracks) != 1 || len(k7.Tracks[0].Response.Frames) != 3 {
+		t.Fatalf("unexpected tracks: %+v", k7.Tracks)
+	}
+
+	frames := k7.Tracks[0].Response.Frames
+	if string(frames[0].Payload) != "hello" || frames[0].FromClient || string(frames[1].Payload) != "ping" || !frames[1].FromClient {
+		t.Errorf("unexpected frames: %+v", frames)
+	}
+
+	// the cassette is replaced atomically, no temporary files are left
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 {
+		t.Errorf("unexpected files in the cassettes dir: %v", files)
+	}
+
+	if data, _ := ioutil.ReadFile(files[0]); len(data) == 0 {
+		t.Errorf("empty cassette")
+	}
+}
+
+func TestVCRControlPanel_Flush_play(t *testing.T) {
+	dir := t.TempDir()
+
+	vcr := NewVCR("missing", &VCRConfig{CassettePath: dir, DisableRecording: true})
+	if err := vcr.Flush(); err != nil {
+		t.Fatalf("failed to flush: %v", err)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 0 {
+		t.Errorf("cassette is written in play mode: %v", files)
+	}
+}
@@ -0,0 +1,95 @@
+package govcr
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const grpcPrefixLength = 5
+
+// Message is a length-prefixed gRPC message.
+type Message struct {
+	// Delay is the time elapsed since the previous message or since the response header
+	// for the first message. It's only recorded for the response messages.
+	Delay time.Duration `json:",omitempty"`
+
+	Compressed bool `json:",omitempty"`
+	Data       []byte
+}
+
+// isGRPC checks whether the header belongs to a gRPC request or response.
+// gRPC-Web is not supported since its trailers are sent in the body.
+func isGRPC(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && (mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+"))
+}
+
+// encode returns the wire representation of the message.
+func (m Message) encode() []byte {
+	buf := make([]byte, grpcPrefixLength, grpcPrefixLength+len(m.Data))
+	if m.Compressed {
+		buf[0] = 1
+	}
+	binary.BigEndian.PutUint32(buf[1:], uint32(len(m.Data)))
+
+	return append(buf, m.Data...)
+}
+
+// encodeMessages returns the wire representation of the messages.
+func encodeMessages(messages []Message) []byte {
+	var buf bytes.Buffer
+	for _, m := range messages {
+		buf.Write(m.encode())
+	}
+
+	return buf.Bytes()
+}
+
+// messagesToChunks converts the messages to the chunks of the streamed response.
+func messagesToChunks(messages []Message) []Chunk {
+	chunks := make([]Chunk, 0, len(messages))
+	for _, m := range messages {
+		chunks = append(chunks, Chunk{Delay: m.Delay, Data: m.encode()})
+	}
+
+	return chunks
+}
+
+// parseMessages splits the recorded chunks into gRPC messages. The time of the message
+// is the time of the chunk that completes the message.
+// It returns false if the data doesn't end with a complete message.
+func parseMessages(chunks []Chunk) ([]Message, bool) {
+	var (
+		buf      []byte
+		messages []Message
+		elapsed  time.Duration
+		last     time.Duration
+	)
+
+	for _, chunk := range chunks {
+		elapsed += chunk.Delay
+		buf = append(buf, chunk.Data...)
+
+		for len(buf) >= grpcPrefixLength {
+			length := int(binary.BigEndian.Uint32(buf[1:grpcPrefixLength]))
+			if len(buf) < grpcPrefixLength+length {
+				break
+			}
+
+			messages = append(messages, Message{
+				Delay:      elapsed - last,
+				Compressed: buf[0] == 1,
+				Data:       append([]byte{}, buf[grpcPrefixLength:grpcPrefixLength+length]...),
+			})
+
+			last = elapsed
+			buf = buf[grpcPrefixLength+length:]
+		}
+	}
+
+	return messages, len(buf) == 0
+}
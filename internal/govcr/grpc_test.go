@@ -0,0 +1,207 @@
+package govcr
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseMessages(t *testing.T) {
+	ms := time.Millisecond
+
+	first := Message{Data: []byte("first")}.encode()
+	second := Message{Compressed: true, Data: []byte("second")}.encode()
+
+	tests := []struct {
+		name   string
+		chunks []Chunk
+		want   []Message
+		wantOK bool
+	}{
+		{
+			name:   "empty",
+			wantOK: true,
+		},
+		{
+			name:   "single message",
+			chunks: []Chunk{{Delay: 10 * ms, Data: first}},
+			want:   []Message{{Delay: 10 * ms, Data: []byte("first")}},
+			wantOK: true,
+		},
+		{
+			name:   "empty message",
+			chunks: []Chunk{{Data: Message{}.encode()}},
+			want:   []Message{{Data: []byte{}}},
+			wantOK: true,
+		},
+		{
+			name:   "messages in one chunk",
+			chunks: []Chunk{{Delay: 10 * ms, Data: append(append([]byte{}, first...), second...)}},
+			want:   []Message{{Delay: 10 * ms, Data: []byte("first")}, {Compressed: true, Data: []byte("second")}},
+			wantOK: true,
+		},
+		{
+			name:   "message split between chunks",
+			chunks: []Chunk{{Delay: 10 * ms, Data: first[:3]}, {Delay: 20 * ms, Data: first[3:7]}, {Delay: 30 * ms, Data: append(first[7:], second...)}},
+			want:   []Message{{Delay: 60 * ms, Data: []byte("first")}, {Compressed: true, Data: []byte("second")}},
+			wantOK: true,
+		},
+		{
+			name:   "delays between messages",
+			chunks: []Chunk{{Delay: 10 * ms, Data: first}, {Delay: 20 * ms, Data: second}},
+			want:   []Message{{Delay: 10 * ms, Data: []byte("first")}, {Delay: 20 * ms, Compressed: true, Data: []byte("second")}},
+			wantOK: true,
+		},
+		{
+			name:   "truncated prefix",
+			chunks: []Chunk{{Data: append(append([]byte{}, first...), second[:3]...)}},
+			want:   []Message{{Data: []byte("first")}},
+		},
+		{
+			name:   "truncated data",
+			chunks: []Chunk{{Data: first[:len(first)-1]}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseMessages(tt.chunks)
+			if ok != tt.wantOK {
+				t.Errorf("parseMessages ok = %v, want: %v", ok, tt.wantOK)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMessages = %+v, want: %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessage_encode(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    []byte
+	}{
+		{name: "empty", message: Message{}, want: []byte{0, 0, 0, 0, 0}},
+		{name: "uncompressed", message: Message{Data: []byte("hi")}, want: []byte{0, 0, 0, 0, 2, 'h', 'i'}},
+		{name: "compressed", message: Message{Compressed: true, Data: []byte("hi")}, want: []byte{1, 0, 0, 0, 2, 'h', 'i'}},
+		{name: "long", message: Message{Data: make([]byte, 0x10203)}, want: append([]byte{0, 0, 1, 2, 3}, make([]byte, 0x10203)...)},
+		{name: "delay is not encoded", message: Message{Delay: time.Second, Data: []byte("hi")}, want: []byte{0, 0, 0, 0, 2, 'h', 'i'}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.message.encode(); !bytes.Equal(got, tt.want) {
+				t.Errorf("encode = % x, want: % x", got[:grpcPrefixLength], tt.want[:grpcPrefixLength])
+			}
+		})
+	}
+}
+
+func Test_encodeMessages(t *testing.T) {
+	messages := []Message{{Data: []byte("a")}, {Compressed: true, Data: []byte("bc")}}
+
+	want := []byte{0, 0, 0, 0, 1, 'a', 1, 0, 0, 0, 2, 'b', 'c'}
+	if got := encodeMessages(messages); !bytes.Equal(got, want) {
+		t.Errorf("encodeMessages = % x, want: % x", got, want)
+	}
+
+	// the encoded messages are parsed back to the same messages
+	if got, ok := parseMessages([]Chunk{{Data: want}}); !ok || !reflect.DeepEqual(got, messages) {
+		t.Errorf("parsed back: %+v %v", got, ok)
+	}
+}
+
+func Test_messagesToChunks(t *testing.T) {
+	messages := []Message{{Delay: time.Second, Data: []byte("a")}, {Compressed: true, Data: []byte("bc")}}
+
+	want := []Chunk{{Delay: time.Second, Data: []byte{0, 0, 0, 0, 1, 'a'}}, {Data: []byte{1, 0, 0, 0, 2, 'b', 'c'}}}
+	if got := messagesToChunks(messages); !reflect.DeepEqual(got, want) {
+		t.Errorf("messagesToChunks = %v, want: %v", got, want)
+	}
+}
+
+func Test_vcrTransport_RoundTrip_grpc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		for _, data := range []string{"first", "second"} {
+			w.Write(Message{Data: []byte(data)}.encode())
+			w.(http.Flusher).Flush()
+		}
+
+		w.Header().Set("Grpc-Status", "5")
+		w.Header().Set("Grpc-Message", "not found")
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	request := func(client *http.Client) *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/users.Users/List", bytes.NewReader(Message{Data: []byte("query")}.encode()))
+		req.Header.Set("Content-Type", "application/grpc")
+		req.Header.Set("Te", "trailers")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+
+		return resp
+	}
+
+	tests := []struct {
+		name   string
+		config *VCRConfig
+	}{
+		{name: "record", config: &VCRConfig{CassettePath: dir}},
+		{name: "play", config: &VCRConfig{CassettePath: dir, DisableRecording: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vcr := NewVCR("grpc", tt.config)
+
+			resp := request(vcr.Client)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+
+			if messages, ok := parseMessages([]Chunk{{Data: body}}); !ok || len(messages) != 2 || string(messages[1].Data) != "second" {
+				t.Errorf("unexpected messages: %+v %v", messages, ok)
+			}
+
+			// the status of the call is only known from the trailer
+			if resp.Trailer.Get("Grpc-Status") != "5" || resp.Trailer.Get("Grpc-Message") != "not found" {
+				t.Errorf("unexpected trailer: %v", resp.Trailer)
+			}
+
+			if stats := vcr.Stats(); tt.config.DisableRecording && stats.TracksPlayed != 1 {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+		})
+	}
+
+	k7, err := readCassetteFromFile("grpc", dir)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %v", err)
+	}
+
+	track := k7.Tracks[0]
+	if len(track.Request.Messages) != 1 || string(track.Request.Messages[0].Data) != "query" || track.Request.Body != nil {
+		t.Errorf("unexpected recorded request: %+v", track.Request)
+	}
+
+	if len(track.Response.Messages) != 2 || track.Response.Body != nil || track.Response.Chunks != nil {
+		t.Errorf("unexpected recorded response: %+v", track.Response)
+	}
+}
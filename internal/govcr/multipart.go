@@ -0,0 +1,115 @@
+package govcr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Part is a part of a multipart request body. The content of the part is
+// identified by its hash, so random boundaries don't affect matching.
+type Part struct {
+	Name        string `json:",omitempty"`
+	Filename    string `json:",omitempty"`
+	ContentType string `json:",omitempty"`
+	Size        int
+	SHA256      string
+
+	// Value is the content of a text form field, the content of the files is not stored.
+	Value string `json:",omitempty"`
+}
+
+// matches checks whether the part is the same as the recorded one.
+func (p Part) matches(part Part) bool {
+	return p.Name == part.Name && p.Filename == part.Filename && p.ContentType == part.ContentType && p.SHA256 == part.SHA256
+}
+
+// multipartBoundary returns the boundary of the multipart body or an empty string
+// if the header doesn't belong to a multipart request.
+func multipartBoundary(header http.Header) string {
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return ""
+	}
+
+	return params["boundary"]
+}
+
+// parseParts splits the multipart body into parts.
+func parseParts(boundary string, body []byte) ([]Part, error) {
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+
+	var parts []Part
+	for {
+		p, err := reader.NextRawPart()
+		if err == io.EOF {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadAll(p)
+		if err != nil {
+			return nil, err
+		}
+
+		sum := sha256.Sum256(data)
+
+		part := Part{
+			Name:        p.FormName(),
+			Filename:    p.FileName(),
+			ContentType: p.Header.Get("Content-Type"),
+			Size:        len(data),
+			SHA256:      hex.EncodeToString(sum[:]),
+		}
+
+		if part.Filename == "" && utf8.Valid(data) {
+			part.Value = string(data)
+		}
+
+		parts = append(parts, part)
+	}
+}
+
+// partsResemble compares the recorded parts with the parts of the request body.
+func partsResemble(recorded []Part, header http.Header, body []byte) bool {
+	boundary := multipartBoundary(header)
+	if boundary == "" {
+		return false
+	}
+
+	parts, err := parseParts(boundary, body)
+	if err != nil || len(parts) != len(recorded) {
+		return false
+	}
+
+	for i := range parts {
+		if !recorded[i].matches(parts[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// withoutBoundary returns a copy of the header of a multipart request without
+// the values that depend on the boundary.
+func withoutBoundary(header http.Header) http.Header {
+	header = cloneHeader(header)
+
+	if mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type")); err == nil {
+		header.Set("Content-Type", mediaType)
+	}
+
+	header.Del("Content-Length")
+
+	return header
+}
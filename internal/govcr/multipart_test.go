@@ -0,0 +1,240 @@
+package govcr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sha256Hex returns the hash of the data the way it is stored on the cassette.
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// transportFunc is an http.RoundTripper implemented by a function.
+type transportFunc func(*http.Request) (*http.Response, error)
+
+func (f transportFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// newUpload returns the multipart body with a text field and a file,
+// the boundary is random unless it's given.
+func newUpload(t *testing.T, file, boundary string) (string, []byte) {
+	var buf bytes.Buffer
+
+	w := multipart.NewWriter(&buf)
+	if boundary != "" {
+		w.SetBoundary(boundary)
+	}
+
+	w.WriteField("title", "avatar")
+
+	fw, err := w.CreateFormFile("file", "avatar.png")
+	if err != nil {
+		t.Fatalf("failed to create form file: %v", err)
+	}
+	fw.Write([]byte(file))
+
+	w.Close()
+
+	return w.FormDataContentType(), buf.Bytes()
+}
+
+func Test_parseParts(t *testing.T) {
+	contentType, body := newUpload(t, "\x89PNG\xff", "")
+
+	tests := []struct {
+		name     string
+		boundary string
+		body     []byte
+		want     []Part
+		wantErr  bool
+	}{
+		{
+			name:     "form field and file",
+			boundary: multipartBoundary(http.Header{"Content-Type": {contentType}}),
+			body:     body,
+			want: []Part{
+				{Name: "title", Size: 6, SHA256: sha256Hex("avatar"), Value: "avatar"},
+				{Name: "file", Filename: "avatar.png", ContentType: "application/octet-stream", Size: 5, SHA256: sha256Hex("\x89PNG\xff")},
+			},
+		},
+		{
+			name:     "no parts",
+			boundary: "b",
+			body:     []byte("--b--\r\n"),
+		},
+		{
+			name:     "wrong boundary",
+			boundary: "other",
+			body:     body,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseParts(tt.boundary, tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseParts error = %v, wantErr: %v", err, tt.wantErr)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseParts = %+v, want: %+v", got, tt.want)
+			}
+
+			for i := range got {
+				want := tt.want[i]
+				if got[i].Name != want.Name || got[i].Filename != want.Filename || got[i].ContentType != want.ContentType ||
+					got[i].Size != want.Size || got[i].Value != want.Value || got[i].SHA256 != want.SHA256 {
+					t.Errorf("part %d = %+v, want: %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func Test_partsResemble(t *testing.T) {
+	contentType, body := newUpload(t, "image", "")
+	recorded, err := parseParts(multipartBoundary(http.Header{"Content-Type": {contentType}}), body)
+	if err != nil {
+		t.Fatalf("failed to parse parts: %v", err)
+	}
+
+	sameType, sameBody := newUpload(t, "image", "")
+	changedType, changedBody := newUpload(t, "other image", "")
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        bool
+	}{
+		{name: "same parts, different boundary", contentType: sameType, body: sameBody, want: true},
+		{name: "changed file", contentType: changedType, body: changedBody},
+		{name: "missing part", contentType: "multipart/form-data; boundary=b", body: []byte("--b\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\navatar\r\n--b--\r\n")},
+		{name: "not multipart", contentType: "application/json", body: []byte("{}")},
+		{name: "malformed body", contentType: sameType, body: []byte("garbage")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := partsResemble(recorded, http.Header{"Content-Type": {tt.contentType}}, tt.body); got != tt.want {
+				t.Errorf("partsResemble = %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withoutBoundary(t *testing.T) {
+	header := http.Header{
+		"Content-Type":   {"multipart/form-data; boundary=abc"},
+		"Content-Length": {"123"},
+		"X-Request-Id":   {"1"},
+	}
+
+	got := withoutBoundary(header)
+	if got.Get("Content-Type") != "multipart/form-data" || got.Get("Content-Length") != "" || got.Get("X-Request-Id") != "1" {
+		t.Errorf("withoutBoundary = %v", got)
+	}
+
+	// the original header is shared with the track and must not change
+	if header.Get("Content-Type") != "multipart/form-data; boundary=abc" || header.Get("Content-Length") != "123" {
+		t.Errorf("original header is changed: %v", header)
+	}
+}
+
+func Test_vcrTransport_RoundTrip_multipart(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 1}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	upload := func(client *http.Client, file, boundary string) (*http.Response, error) {
+		contentType, body := newUpload(t, file, boundary)
+
+		req, _ := http.NewRequest("POST", server.URL+"/avatars", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+		return client.Do(req)
+	}
+
+	recorder := NewVCR("multipart", &VCRConfig{CassettePath: dir})
+	resp, err := upload(recorder.Client, "image", "")
+	if err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	k7, err := readCassetteFromFile("multipart", dir)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %v", err)
+	}
+
+	if parts := k7.Tracks[0].Request.Parts; len(parts) != 2 || parts[0].Value != "avatar" || parts[1].Filename != "avatar.png" || parts[1].Value != "" {
+		t.Fatalf("unexpected recorded parts: %+v", parts)
+	}
+
+	// the live transport is only reached by the requests that are not found on the cassette
+	var closest []TrackDiff
+	errMissed := errors.New("missed")
+
+	player := NewVCR("multipart", &VCRConfig{
+		CassettePath:     dir,
+		DisableRecording: true,
+		Client: &http.Client{Transport: transportFunc(func(r *http.Request) (*http.Response, error) {
+			closest = ClosestTracks(r.Context(), 1)
+			return nil, errMissed
+		})},
+	})
+
+	// the new upload has a different boundary and Content-Length
+	resp, err = upload(player.Client, "image", "short")
+	if err != nil {
+		t.Fatalf("failed to play: %v", err)
+	}
+
+	if body, _ := ioutil.ReadAll(resp.Body); string(body) != `{"id": 1}` {
+		t.Errorf("unexpected played back body: %s", body)
+	}
+	resp.Body.Close()
+
+	// the file with a different hash is a miss
+	if _, err := upload(player.Client, "other image", "short"); !errors.Is(err, errMissed) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(closest) != 1 {
+		t.Fatalf("unexpected closest tracks: %+v", closest)
+	}
+
+	// the track is already played so its state differs too
+	var bodyDiff *FieldDiff
+	for i, fd := range closest[0].Diffs {
+		switch {
+		case fd.Field == "body":
+			bodyDiff = &closest[0].Diffs[i]
+		case fd.Field != "state":
+			t.Errorf("unexpected diff: %+v", fd)
+		}
+	}
+
+	if bodyDiff == nil || !strings.Contains(bodyDiff.Expected, "file(avatar.png) sha256:") || bodyDiff.Expected == bodyDiff.Actual ||
+		!strings.HasPrefix(bodyDiff.Actual, "title() sha256:") {
+		t.Errorf("unexpected body diff: %+v", bodyDiff)
+	}
+}
@@ -0,0 +1,40 @@
+package govcr
+
+import "context"
+
+// Results of the requests handled by the VCR.
+const (
+	// ResultPlayed means the response was played back from a track on the cassette.
+	ResultPlayed = "played"
+
+	// ResultRecorded means the request was sent to the live server and recorded.
+	ResultRecorded = "recorded"
+
+	// ResultMissed means no track was found while the recording was disabled.
+	ResultMissed = "missed"
+)
+
+// Outcome describes how the VCR handled a request.
+type Outcome struct {
+	// Result is one of ResultPlayed, ResultRecorded or ResultMissed.
+	Result string
+
+	// Track is the number of the played back track starting from 0, it's -1 if no track was played.
+	Track int
+}
+
+type outcomeKey struct{}
+
+// WithOutcome returns a copy of the context that makes the VCR report how it handled
+// the request with this context to the outcome. The outcome is set before RoundTrip returns.
+func WithOutcome(ctx context.Context, o *Outcome) context.Context {
+	return context.WithValue(ctx, outcomeKey{}, o)
+}
+
+// setOutcome reports the outcome if it was requested.
+func setOutcome(ctx context.Context, result string, trackNumber int) {
+	if o, ok := ctx.Value(outcomeKey{}).(*Outcome); ok {
+		o.Result = result
+		o.Track = trackNumber
+	}
+}
@@ -0,0 +1,77 @@
+package govcr
+
+// ScenarioStarted is the initial state of every scenario.
+const ScenarioStarted = "Started"
+
+// scenarioState returns the current state of the scenario, the caller must hold k7.mu.
+func (k7 *cassette) scenarioState(name string) string {
+	if state, ok := k7.states[name]; ok {
+		return state
+	}
+
+	return ScenarioStarted
+}
+
+// inState checks whether the track can be played back in the current state of its scenario.
+// The caller must hold k7.mu.
+func (k7 *cassette) inState(t *track) bool {
+	return t.Scenario == "" || t.RequiredState == "" || k7.scenarioState(t.Scenario) == t.RequiredState
+}
+
+// transition moves the scenario of the played back track to its new state.
+// The caller must hold k7.mu.
+func (k7 *cassette) transition(t *track) {
+	if t.Scenario == "" || t.NewState == "" {
+		return
+	}
+
+	if k7.states == nil {
+		k7.states = map[string]string{}
+	}
+
+	k7.states[t.Scenario] = t.NewState
+}
+
+// scenarioStates returns the current state of every scenario on the cassette.
+func (k7 *cassette) scenarioStates() map[string]string {
+	k7.mu.Lock()
+	defer k7.mu.Unlock()
+
+	states := map[string]string{}
+	for _, t := range k7.Tracks {
+		if t.Scenario != "" {
+			states[t.Scenario] = k7.scenarioState(t.Scenario)
+		}
+	}
+
+	return states
+}
+
+// resetScenarios moves the scenarios to the ScenarioStarted state,
+// all scenarios are reset if no names are given.
+func (k7 *cassette) resetScenarios(names ...string) {
+	k7.mu.Lock()
+	defer k7.mu.Unlock()
+
+	if len(names) == 0 {
+		k7.states = nil
+		return
+	}
+
+	for _, name := range names {
+		delete(k7.states, name)
+	}
+}
+
+// ScenarioStates returns the current state of every scenario on the cassette.
+func (vcr *VCRControlPanel) ScenarioStates() map[string]string {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+	return vcrT.Cassette.scenarioStates()
+}
+
+// ResetScenarios moves the scenarios to the ScenarioStarted state,
+// all scenarios are reset if no names are given.
+func (vcr *VCRControlPanel) ResetScenarios(names ...string) {
+	vcrT := vcr.Client.Transport.(*vcrTransport)
+	vcrT.Cassette.resetScenarios(names...)
+}
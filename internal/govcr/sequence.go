@@ -0,0 +1,71 @@
+package govcr
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+)
+
+// SequenceError is returned in the sequential mode when the request
+// doesn't match the next track on the cassette.
+type SequenceError struct {
+	// Position is the number of the expected track on the cassette starting from 0.
+	Position int
+
+	// Expected is the dump of the request of the expected track,
+	// it's empty if all the tracks have been played.
+	Expected string
+
+	// Actual is the dump of the received request.
+	Actual string
+}
+
+// Error is an implementation of error.
+func (e *SequenceError) Error() string {
+	if e.Expected == "" {
+		return fmt.Sprintf("unexpected request, all %d tracks have been played, got:\n%s", e.Position, e.Actual)
+	}
+
+	return fmt.Sprintf("out of sequence request, expected track #%d:\n%s\ngot:\n%s", e.Position, e.Expected, e.Actual)
+}
+
+// findTrack returns the number of the track to play back for the request.
+// The caller must hold cassette.mu.
+func (pcbr *pcb) findTrack(cassette *cassette, req *http.Request) (int, error) {
+	if !pcbr.Sequential {
+		return pcbr.seekTrack(cassette, req), nil
+	}
+
+	return pcbr.seekNextTrack(cassette, req)
+}
+
+// seekNextTrack checks whether the request matches the first track that hasn't been played yet.
+func (pcbr *pcb) seekNextTrack(cassette *cassette, req *http.Request) (int, error) {
+	next := 0
+	for next < len(cassette.Tracks) && cassette.Tracks[next].replayed {
+		next++
+	}
+
+	if next < len(cassette.Tracks) && pcbr.trackMatches(cassette, next, req) {
+		pcbr.Logger.Debug("found the next track", "cassette", cassette.Name, "method", req.Method, "url", req.URL.String(), "track", next)
+		return next, nil
+	}
+
+	seqErr := &SequenceError{Position: next}
+
+	actual, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		return trackNotFound, fmt.Errorf("failed to dump request: %v", err)
+	}
+	seqErr.Actual = string(actual)
+
+	if next < len(cassette.Tracks) {
+		expected, err := cassette.Tracks[next].Request.dump()
+		if err != nil {
+			return trackNotFound, err
+		}
+		seqErr.Expected = expected
+	}
+
+	return trackNotFound, seqErr
+}
@@ -0,0 +1,194 @@
+package govcr
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event is a server-sent event recorded from a text/event-stream response.
+// Only the fields that were present in the stream are played back: ID and Data are nil
+// when the event has no id or data lines, since their empty values are meaningful
+// (an empty data line dispatches a message, an empty id resets the last event ID).
+type Event struct {
+	// Delay is the time elapsed since the previous event or since the response header
+	// for the first event.
+	Delay time.Duration
+
+	// Comment keeps the comment lines, e.g. heartbeats, the block that consists
+	// of the comments only is recorded as an event too.
+	Comment string  `json:",omitempty"`
+	ID      *string `json:",omitempty"`
+	Event   string  `json:",omitempty"`
+	Retry   string  `json:",omitempty"`
+	Data    *string `json:",omitempty"`
+}
+
+// isEventStream checks whether the response is a stream of server-sent events.
+func isEventStream(header http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// encode returns the wire representation of the event.
+func (e Event) encode() []byte {
+	var buf bytes.Buffer
+
+	if e.Comment != "" {
+		for _, line := range strings.Split(e.Comment, "\n") {
+			buf.WriteString(": " + line + "\n")
+		}
+	}
+
+	if e.ID != nil {
+		buf.WriteString("id: " + *e.ID + "\n")
+	}
+
+	if e.Event != "" {
+		buf.WriteString("event: " + e.Event + "\n")
+	}
+
+	if e.Retry != "" {
+		buf.WriteString("retry: " + e.Retry + "\n")
+	}
+
+	if e.Data != nil {
+		for _, line := range strings.Split(*e.Data, "\n") {
+			buf.WriteString("data: " + line + "\n")
+		}
+	}
+
+	buf.WriteString("\n")
+
+	return buf.Bytes()
+}
+
+// eventsToChunks converts events to the chunks of the streamed response.
+func eventsToChunks(events []Event) []Chunk {
+	chunks := make([]Chunk, 0, len(events))
+	for _, e := range events {
+		chunks = append(chunks, Chunk{Delay: e.Delay, Data: e.encode()})
+	}
+
+	return chunks
+}
+
+// eventParser splits a text/event-stream into events.
+// See https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+type eventParser struct {
+	buf       []byte
+	event     Event
+	comments  []string
+	data      []string
+	hasFields bool
+
+	// elapsed is the time since the response header, lastEvent is the time of the last event
+	elapsed   time.Duration
+	lastEvent time.Duration
+
+	events []Event
+}
+
+// parseEvents splits the recorded chunks into events. The time of the event
+// is the time of the chunk that completes the event.
+func parseEvents(chunks []Chunk) []Event {
+	p := &eventParser{}
+	for _, chunk := range chunks {
+		p.elapsed += chunk.Delay
+		p.write(chunk.Data)
+	}
+
+	p.close()
+
+	return p.events
+}
+
+func (p *eventParser) write(data []byte) {
+	p.buf = append(p.buf, data...)
+
+	for {
+		i := bytes.IndexAny(p.buf, "\r\n")
+		if i < 0 {
+			return
+		}
+
+		// CR may be followed by LF in the next chunk
+		if p.buf[i] == '\r' && i == len(p.buf)-1 {
+			return
+		}
+
+		line := string(p.buf[:i])
+		if p.buf[i] == '\r' && p.buf[i+1] == '\n' {
+			i++
+		}
+		p.buf = p.buf[i+1:]
+
+		p.line(line)
+	}
+}
+
+func (p *eventParser) close() {
+	if len(p.buf) > 0 {
+		p.line(strings.TrimSuffix(string(p.buf), "\r"))
+		p.buf = nil
+	}
+
+	p.dispatch()
+}
+
+func (p *eventParser) line(line string) {
+	if line == "" {
+		p.dispatch()
+		return
+	}
+
+	if strings.HasPrefix(line, ":") {
+		p.comments = append(p.comments, strings.TrimPrefix(line[1:], " "))
+		p.hasFields = true
+		return
+	}
+
+	field, value := line, ""
+	if i := strings.Index(line, ":"); i >= 0 {
+		field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+	}
+
+	switch field {
+	case "id":
+		p.event.ID = &value
+	case "event":
+		p.event.Event = value
+	case "retry":
+		p.event.Retry = value
+	case "data":
+		p.data = append(p.data, value)
+	default:
+		return
+	}
+
+	p.hasFields = true
+}
+
+func (p *eventParser) dispatch() {
+	if !p.hasFields {
+		return
+	}
+
+	p.event.Comment = strings.Join(p.comments, "\n")
+	if p.data != nil {
+		data := strings.Join(p.data, "\n")
+		p.event.Data = &data
+	}
+
+	p.event.Delay = p.elapsed - p.lastEvent
+	p.lastEvent = p.elapsed
+
+	p.events = append(p.events, p.event)
+
+	p.event = Event{}
+	p.comments = nil
+	p.data = nil
+	p.hasFields = false
+}
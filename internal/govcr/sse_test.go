@@ -0,0 +1,270 @@
+package govcr
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// str returns a pointer to the string for the optional fields of events.
+func str(s string) *string {
+	return &s
+}
+
+func Test_parseEvents(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name   string
+		chunks []Chunk
+		want   []Event
+	}{
+		{
+			name:   "single event",
+			chunks: []Chunk{{Delay: 10 * ms, Data: []byte("data: hello\n\n")}},
+			want:   []Event{{Delay: 10 * ms, Data: str("hello")}},
+		},
+		{
+			name:   "multi-line data",
+			chunks: []Chunk{{Data: []byte("data: first\ndata:second\ndata\n\n")}},
+			want:   []Event{{Data: str("first\nsecond\n")}},
+		},
+		{
+			name:   "id, event and retry",
+			chunks: []Chunk{{Data: []byte("id: 1\nevent: update\nretry: 1000\ndata: {}\n\n")}},
+			want:   []Event{{ID: str("1"), Event: "update", Retry: "1000", Data: str("{}")}},
+		},
+		{
+			name:   "CRLF",
+			chunks: []Chunk{{Data: []byte("data: a\r\n\r\ndata: b\r\n\r\n")}},
+			want:   []Event{{Data: str("a")}, {Data: str("b")}},
+		},
+		{
+			name:   "CR",
+			chunks: []Chunk{{Data: []byte("data: a\r\rdata: b\r\r")}},
+			want:   []Event{{Data: str("a")}, {Data: str("b")}},
+		},
+		{
+			name:   "CRLF split between chunks",
+			chunks: []Chunk{{Data: []byte("data: a\r")}, {Delay: 5 * ms, Data: []byte("\n\r")}, {Delay: 5 * ms, Data: []byte("\n")}},
+			want:   []Event{{Delay: 10 * ms, Data: str("a")}},
+		},
+		{
+			name:   "event split between chunks",
+			chunks: []Chunk{{Delay: 10 * ms, Data: []byte("da")}, {Delay: 20 * ms, Data: []byte("ta: hel")}, {Delay: 30 * ms, Data: []byte("lo\n\n")}},
+			want:   []Event{{Delay: 60 * ms, Data: str("hello")}},
+		},
+		{
+			name: "delays between events",
+			chunks: []Chunk{
+				{Delay: 10 * ms, Data: []byte("data: 1\n\ndata: 2\n\n")},
+				{Delay: 20 * ms, Data: []byte("data: 3\n\n")},
+			},
+			want: []Event{{Delay: 10 * ms, Data: str("1")}, {Data: str("2")}, {Delay: 20 * ms, Data: str("3")}},
+		},
+		{
+			name: "comments and unknown fields",
+			chunks: []Chunk{
+				{Data: []byte(": keep-alive\n\n")},
+				{Delay: 10 * ms, Data: []byte("foo: bar\n: note\ndata: x\n\n")},
+			},
+			want: []Event{{Comment: "keep-alive"}, {Delay: 10 * ms, Comment: "note", Data: str("x")}},
+		},
+		{
+			name:   "id without data",
+			chunks: []Chunk{{Data: []byte("id: 1\n\nid\n\n")}},
+			want:   []Event{{ID: str("1")}, {ID: str("")}},
+		},
+		{
+			name:   "retry without data",
+			chunks: []Chunk{{Data: []byte("retry: 1000\n\n")}},
+			want:   []Event{{Retry: "1000"}},
+		},
+		{
+			name:   "empty data",
+			chunks: []Chunk{{Data: []byte("data\n\n")}},
+			want:   []Event{{Data: str("")}},
+		},
+		{
+			name:   "unterminated event",
+			chunks: []Chunk{{Data: []byte("data: a\n\ndata: b\r")}},
+			want:   []Event{{Data: str("a")}, {Data: str("b")}},
+		},
+		{
+			name:   "empty stream",
+			chunks: []Chunk{{Data: []byte("\n\n")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseEvents(tt.chunks); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEvents = %+v, want: %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_eventParser_write(t *testing.T) {
+	var p eventParser
+
+	p.write([]byte("id: 1\ndata: a"))
+	if len(p.events) != 0 || string(p.buf) != "data: a" {
+		t.Fatalf("unexpected state: %+v %q", p.events, p.buf)
+	}
+
+	// the CR is kept until it's known whether LF follows it
+	p.write([]byte("\r"))
+	if string(p.buf) != "data: a\r" {
+		t.Fatalf("unexpected buffer: %q", p.buf)
+	}
+
+	p.write([]byte("\n\n"))
+	if want := []Event{{ID: str("1"), Data: str("a")}}; !reflect.DeepEqual(p.events, want) || len(p.buf) != 0 {
+		t.Errorf("events = %+v, buffer: %q, want: %+v", p.events, p.buf, want)
+	}
+
+	// the fields are reset after the event is dispatched
+	p.write([]byte("data: b\n\n"))
+	if want := (Event{Data: str("b")}); !reflect.DeepEqual(p.events[1], want) {
+		t.Errorf("event = %+v, want: %+v", p.events[1], want)
+	}
+}
+
+func TestEvent_encode(t *testing.T) {
+	tests := []struct {
+		name  string
+		event Event
+		want  string
+	}{
+		{name: "data", event: Event{Data: str("hello")}, want: "data: hello\n\n"},
+		{name: "empty data", event: Event{Data: str("")}, want: "data: \n\n"},
+		{name: "id only", event: Event{ID: str("1")}, want: "id: 1\n\n"},
+		{name: "empty id", event: Event{ID: str("")}, want: "id: \n\n"},
+		{name: "retry only", event: Event{Retry: "1000"}, want: "retry: 1000\n\n"},
+		{name: "comment", event: Event{Comment: "keep-alive\nping"}, want: ": keep-alive\n: ping\n\n"},
+		{name: "multi-line data", event: Event{Data: str("a\nb")}, want: "data: a\ndata: b\n\n"},
+		{name: "all fields", event: Event{Comment: "note", ID: str("1"), Event: "update", Retry: "1000", Data: str("{}")}, want: ": note\nid: 1\nevent: update\nretry: 1000\ndata: {}\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.event.encode()
+			if string(got) != tt.want {
+				t.Errorf("encode = %q, want: %q", got, tt.want)
+			}
+
+			// the encoded event is parsed back to the same event
+			if events := parseEvents([]Chunk{{Data: got}}); len(events) != 1 || !reflect.DeepEqual(events[0], tt.event) {
+				t.Errorf("parsed back: %+v", events)
+			}
+		})
+	}
+}
+
+func Test_eventsToChunks(t *testing.T) {
+	events := []Event{{Delay: time.Second, Data: str("a")}, {Event: "end", Data: str("b")}}
+
+	want := []Chunk{{Delay: time.Second, Data: []byte("data: a\n\n")}, {Data: []byte("event: end\ndata: b\n\n")}}
+	if got := eventsToChunks(events); !reflect.DeepEqual(got, want) {
+		t.Errorf("eventsToChunks = %q, want: %q", got, want)
+	}
+}
+
+func Test_vcrTransport_RoundTrip_events(t *testing.T) {
+	const stream = ": heartbeat\n\nid: 1\ndata: 1\n\nid: 2\ndata: 2\n\n"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(stream)))
+		io.WriteString(w, stream)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+
+	recorder := NewVCR("events", &VCRConfig{CassettePath: dir})
+	resp, err := recorder.Client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	k7, err := readCassetteFromFile("events", dir)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %v", err)
+	}
+
+	response := &k7.Tracks[0].Response
+	want := []Event{{Comment: "heartbeat"}, {ID: str("1"), Data: str("1")}, {ID: str("2"), Data: str("2")}}
+	if !reflect.DeepEqual(response.Events, want) || response.Chunks != nil || response.Body != nil {
+		t.Fatalf("unexpected recorded response: %+v", response)
+	}
+
+	// edit the events on the cassette, they no longer match the recorded Content-Length
+	response.Events = []Event{
+		{Comment: "heartbeat"},
+		{Data: str("first")},
+		{Delay: 50 * time.Millisecond, Event: "update", Data: str("second\nline")},
+		{Delay: 100 * time.Millisecond, ID: str("3"), Data: str("third")},
+	}
+
+	if err := k7.save(); err != nil {
+		t.Fatalf("failed to save cassette: %v", err)
+	}
+
+	player := NewVCR("events", &VCRConfig{
+		CassettePath:     dir,
+		DisableRecording: true,
+		ChunkDelayFunc:   func(d time.Duration) time.Duration { return d },
+	})
+
+	resp, err = player.Client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to play: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength != -1 || resp.Header.Get("Content-Length") != "" {
+		t.Errorf("unexpected length of the played back response: %d %q", resp.ContentLength, resp.Header.Get("Content-Length"))
+	}
+
+	start := time.Now()
+
+	var (
+		p        eventParser
+		elapsed  []time.Duration
+		received int
+	)
+
+	buf := make([]byte, 1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			p.write(buf[:n])
+		}
+
+		for ; received < len(p.events); received++ {
+			elapsed = append(elapsed, time.Since(start))
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	want = []Event{{Comment: "heartbeat"}, {Data: str("first")}, {Event: "update", Data: str("second\nline")}, {ID: str("3"), Data: str("third")}}
+	if !reflect.DeepEqual(p.events, want) {
+		t.Fatalf("played back events = %+v, want: %+v", p.events, want)
+	}
+
+	if elapsed[2] < 50*time.Millisecond || elapsed[3] < 150*time.Millisecond || elapsed[3] > time.Second {
+		t.Errorf("unexpected timing of the events: %v", elapsed)
+	}
+}
@@ -0,0 +1,125 @@
+package govcr
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Chunk is a part of a streamed response body as it was received from the live server.
+type Chunk struct {
+	// Delay is the time elapsed since the previous chunk or since the response header
+	// for the first chunk.
+	Delay time.Duration
+
+	Data []byte
+}
+
+// recordingBody tees the response body into a buffer while it's being read.
+// When the body is read to the end or closed, the recorded data is passed to
+// the done function: either the body or, for the streamed responses, the chunks.
+type recordingBody struct {
+	io.ReadCloser
+
+	// streamed indicates whether chunk boundaries and timing should be recorded
+	streamed bool
+	last     time.Time
+	body     bytes.Buffer
+	chunks   []Chunk
+
+	once sync.Once
+	done func(body []byte, chunks []Chunk)
+}
+
+// newRecordingBody wraps the response body. Responses of unknown length
+// (i.e. chunked or streamed until the connection is closed) are recorded
+// chunk by chunk.
+func newRecordingBody(resp *http.Response, done func(body []byte, chunks []Chunk)) *recordingBody {
+	return &recordingBody{
+		ReadCloser: resp.Body,
+		streamed:   resp.ContentLength < 0,
+		last:       time.Now(),
+		done:       done,
+	}
+}
+
+// Read is an implementation of io.Reader.
+func (rb *recordingBody) Read(p []byte) (int, error) {
+	n, err := rb.ReadCloser.Read(p)
+	if n > 0 {
+		// the streamed body is only kept as chunks so it's not held in memory twice
+		if rb.streamed {
+			now := time.Now()
+			rb.chunks = append(rb.chunks, Chunk{Delay: now.Sub(rb.last), Data: append([]byte(nil), p[:n]...)})
+			rb.last = now
+		} else {
+			rb.body.Write(p[:n])
+		}
+	}
+
+	if err != nil {
+		rb.finish()
+	}
+
+	return n, err
+}
+
+// Close is an implementation of io.Closer.
+// The track is recorded even if the body has not been read to the end.
+func (rb *recordingBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.finish()
+	return err
+}
+
+func (rb *recordingBody) finish() {
+	rb.once.Do(func() {
+		if rb.streamed {
+			rb.done(nil, rb.chunks)
+			return
+		}
+
+		rb.done(rb.body.Bytes(), nil)
+	})
+}
+
+// chunkedBody plays back the chunks of a streamed response.
+type chunkedBody struct {
+	ctx       context.Context
+	chunks    []Chunk
+	delayFunc ChunkDelayFunc
+
+	// pending is the part of the current chunk that hasn't been read yet
+	pending []byte
+}
+
+// Read is an implementation of io.Reader.
+func (cb *chunkedBody) Read(p []byte) (int, error) {
+	for len(cb.pending) == 0 {
+		if len(cb.chunks) == 0 {
+			return 0, io.EOF
+		}
+
+		chunk := cb.chunks[0]
+		cb.chunks = cb.chunks[1:]
+
+		if err := delay(cb.ctx, cb.delayFunc(chunk.Delay)); err != nil {
+			return 0, err
+		}
+
+		cb.pending = chunk.Data
+	}
+
+	n := copy(p, cb.pending)
+	cb.pending = cb.pending[n:]
+
+	return n, nil
+}
+
+// Close is an implementation of io.Closer.
+func (cb *chunkedBody) Close() error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package govcr
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newStreamingServer starts the server that flushes the parts of the body with the delay between them.
+func newStreamingServer(t *testing.T, delay time.Duration, parts ...string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, part := range parts {
+			if i > 0 {
+				time.Sleep(delay)
+			}
+
+			io.WriteString(w, part)
+			w.(http.Flusher).Flush()
+		}
+	}))
+
+	t.Cleanup(server.Close)
+	return server
+}
+
+func Test_recordingBody(t *testing.T) {
+	tests := []struct {
+		name          string
+		contentLength int64
+		wantBody      string
+		wantChunks    int
+	}{
+		{name: "known length", contentLength: 5, wantBody: "hello"},
+		{name: "streamed", contentLength: -1, wantChunks: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				gotBody   []byte
+				gotChunks []Chunk
+			)
+
+			pr, pw := io.Pipe()
+			go func() {
+				pw.Write([]byte("hel"))
+				pw.Write([]byte("lo"))
+				pw.Close()
+			}()
+
+			rb := newRecordingBody(&http.Response{Body: pr, ContentLength: tt.contentLength}, func(body []byte, chunks []Chunk) {
+				gotBody, gotChunks = body, chunks
+			})
+
+			if data, err := ioutil.ReadAll(rb); err != nil || string(data) != "hello" {
+				t.Fatalf("unexpected read result: %q %v", data, err)
+			}
+
+			if string(gotBody) != tt.wantBody || len(gotChunks) != tt.wantChunks {
+				t.Errorf("got body: %q, chunks: %d, want: %q, %d", gotBody, len(gotChunks), tt.wantBody, tt.wantChunks)
+			}
+		})
+	}
+}
+
+func Test_chunkedBody_Read(t *testing.T) {
+	server := newStreamingServer(t, 50*time.Millisecond, "first ", "second ", "third")
+	dir := t.TempDir()
+
+	recorder := NewVCR("stream", &VCRConfig{CassettePath: dir})
+
+	resp, err := recorder.Client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+
+	if data, _ := ioutil.ReadAll(resp.Body); string(data) != "first second third" {
+		t.Fatalf("unexpected recorded body: %q", data)
+	}
+	resp.Body.Close()
+
+	k7, err := readCassetteFromFile("stream", dir)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %v", err)
+	}
+
+	// the streamed body is stored once, as chunks
+	response := k7.Tracks[0].Response
+	if response.Body != nil || len(response.Chunks) < 2 {
+		t.Fatalf("unexpected recorded response: body %q, %d chunks", response.Body, len(response.Chunks))
+	}
+
+	var joined []byte
+	for _, chunk := range response.Chunks {
+		joined = append(joined, chunk.Data...)
+	}
+
+	if string(joined) != "first second third" {
+		t.Errorf("unexpected chunks: %q", joined)
+	}
+
+	scaled := func(scale time.Duration) ChunkDelayFunc {
+		return func(d time.Duration) time.Duration { return d * scale }
+	}
+
+	tests := []struct {
+		name      string
+		delayFunc ChunkDelayFunc
+		wantMin   time.Duration
+		wantMax   time.Duration
+	}{
+		{name: "recorded pace", delayFunc: scaled(1), wantMin: 90 * time.Millisecond, wantMax: time.Second},
+		{name: "twice slower", delayFunc: scaled(2), wantMin: 190 * time.Millisecond, wantMax: time.Second},
+		{name: "no delays", delayFunc: scaled(0), wantMax: 40 * time.Millisecond},
+		{name: "instantly by default", wantMax: 40 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			player := NewVCR("stream", &VCRConfig{
+				CassettePath:     dir,
+				DisableRecording: true,
+				ChunkDelayFunc:   tt.delayFunc,
+			})
+
+			resp, err := player.Client.Get(server.URL)
+			if err != nil {
+				t.Fatalf("failed to play: %v", err)
+			}
+			defer resp.Body.Close()
+
+			start := time.Now()
+
+			var parts [][]byte
+			buf := make([]byte, 1024)
+			for {
+				n, err := resp.Body.Read(buf)
+				if n > 0 {
+					parts = append(parts, append([]byte(nil), buf[:n]...))
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("failed to read: %v", err)
+				}
+			}
+
+			elapsed := time.Since(start)
+
+			if len(parts) != len(response.Chunks) || string(bytes.Join(parts, nil)) != "first second third" {
+				t.Errorf("unexpected played back chunks: %q", parts)
+			}
+
+			if elapsed < tt.wantMin || elapsed > tt.wantMax {
+				t.Errorf("played back in %v, want between %v and %v", elapsed, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cb := &chunkedBody{ctx: ctx, chunks: []Chunk{{Delay: time.Minute, Data: []byte("late")}}, delayFunc: func(d time.Duration) time.Duration { return d }}
+
+		cancel()
+		if _, err := cb.Read(make([]byte, 10)); err != context.Canceled {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
@@ -0,0 +1,353 @@
+package govcr
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	opcodeClose = 0x8
+
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errConnClosed = errors.New("govcr: connection is closed")
+
+// Frame is a WebSocket frame recorded from an upgraded connection.
+type Frame struct {
+	// Delay is the time elapsed since the previous frame (sent in any direction)
+	// or since the handshake for the first frame.
+	Delay time.Duration
+
+	// FromClient indicates whether the frame was sent by the client or by the server.
+	FromClient bool
+
+	Fin    bool
+	Rsv    byte `json:",omitempty"`
+	Opcode byte
+
+	// Payload is unmasked.
+	Payload []byte
+}
+
+// isWebSocketUpgrade checks whether the response switches the connection to the WebSocket protocol.
+func isWebSocketUpgrade(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusSwitchingProtocols && strings.EqualFold(resp.Header.Get("Upgrade"), "websocket")
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept header for the client's Sec-WebSocket-Key.
+func websocketAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// encode returns the wire representation of the frame, the payload is never masked
+// since only the server frames are played back.
+func (f Frame) encode() []byte {
+	var buf bytes.Buffer
+
+	b0 := f.Opcode&0x0f | (f.Rsv&0x07)<<4
+	if f.Fin {
+		b0 |= 0x80
+	}
+	buf.WriteByte(b0)
+
+	switch n := len(f.Payload); {
+	case n < 126:
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(126)
+		binary.Write(&buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(127)
+		binary.Write(&buf, binary.BigEndian, uint64(n))
+	}
+
+	buf.Write(f.Payload)
+
+	return buf.Bytes()
+}
+
+// matches checks whether the client frame is the same as the recorded one.
+func (f Frame) matches(frame Frame) bool {
+	return f.FromClient && f.Opcode == frame.Opcode && bytes.Equal(f.Payload, frame.Payload)
+}
+
+// frameParser splits a stream of bytes into WebSocket frames.
+type frameParser struct {
+	buf []byte
+}
+
+// write consumes the data and returns the frames that are complete.
+func (fp *frameParser) write(data []byte) []Frame {
+	fp.buf = append(fp.buf, data...)
+
+	var frames []Frame
+	for {
+		frame, n := decodeFrame(fp.buf)
+		if n == 0 {
+			return frames
+		}
+
+		fp.buf = fp.buf[n:]
+		frames = append(frames, frame)
+	}
+}
+
+// decodeFrame decodes the frame in the beginning of the buffer.
+// It returns the number of bytes consumed or 0 if the frame is incomplete.
+func decodeFrame(buf []byte) (Frame, int) {
+	if len(buf) < 2 {
+		return Frame{}, 0
+	}
+
+	frame := Frame{
+		Fin:    buf[0]&0x80 != 0,
+		Rsv:    (buf[0] >> 4) & 0x07,
+		Opcode: buf[0] & 0x0f,
+	}
+
+	masked := buf[1]&0x80 != 0
+	length := uint64(buf[1] & 0x7f)
+	offset := 2
+
+	switch length {
+	case 126:
+		if len(buf) < offset+2 {
+			return Frame{}, 0
+		}
+		length = uint64(binary.BigEndian.Uint16(buf[offset:]))
+		offset += 2
+	case 127:
+		if len(buf) < offset+8 {
+			return Frame{}, 0
+		}
+		length = binary.BigEndian.Uint64(buf[offset:])
+		offset += 8
+	}
+
+	var mask []byte
+	if masked {
+		if len(buf) < offset+4 {
+			return Frame{}, 0
+		}
+		mask = buf[offset : offset+4]
+		offset += 4
+	}
+
+	if uint64(len(buf)-offset) < length {
+		return Frame{}, 0
+	}
+
+	frame.Payload = append([]byte{}, buf[offset:offset+int(length)]...)
+	if masked {
+		for i := range frame.Payload {
+			frame.Payload[i] ^= mask[i%4]
+		}
+	}
+
+	return frame, offset + int(length)
+}
+
+// recordingConn records the frames sent in both directions over the upgraded connection.
+// When the connection is closed, the recorded frames are passed to the done function.
+type recordingConn struct {
+	io.ReadWriteCloser
+
+	mu     sync.Mutex
+	last   time.Time
+	frames []Frame
+	client frameParser
+	server frameParser
+
+	once sync.Once
+	done func(frames []Frame)
+}
+
+func newRecordingConn(conn io.ReadWriteCloser, done func(frames []Frame)) *recordingConn {
+	return &recordingConn{ReadWriteCloser: conn, last: time.Now(), done: done}
+}
+
+// Read is an implementation of io.Reader, it reads the frames sent by the server.
+func (rc *recordingConn) Read(p []byte) (int, error) {
+	n, err := rc.ReadWriteCloser.Read(p)
+	if n > 0 {
+		rc.record(rc.server.write(p[:n]), false)
+	}
+
+	return n, err
+}
+
+// Write is an implementation of io.Writer, it writes the frames sent by the client.
+func (rc *recordingConn) Write(p []byte) (int, error) {
+	rc.record(rc.client.write(p), true)
+	return rc.ReadWriteCloser.Write(p)
+}
+
+// Close is an implementation of io.Closer.
+func (rc *recordingConn) Close() error {
+	err := rc.ReadWriteCloser.Close()
+
+	rc.once.Do(func() {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		rc.done(rc.frames)
+	})
+
+	return err
+}
+
+func (rc *recordingConn) record(frames []Frame, fromClient bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for _, frame := range frames {
+		now := time.Now()
+		frame.Delay = now.Sub(rc.last)
+		frame.FromClient = fromClient
+		rc.frames = append(rc.frames, frame)
+		rc.last = now
+	}
+}
+
+// replayConn plays back the server side of the recorded WebSocket connection.
+// Server frames are sent in response to the client frames that match the recorded ones.
+type replayConn struct {
+	ctx       context.Context
+	delayFunc ChunkDelayFunc
+
+	mu     sync.Mutex
+	frames []Frame
+	next   int
+	client frameParser
+
+	out       chan Chunk
+	outClosed bool
+	pending   []byte
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newReplayConn(ctx context.Context, frames []Frame, delayFunc ChunkDelayFunc) *replayConn {
+	rc := &replayConn{
+		ctx:       ctx,
+		delayFunc: delayFunc,
+		frames:    frames,
+		// every frame is sent at most once plus the reply to the unexpected close frame
+		out:    make(chan Chunk, len(frames)+1),
+		closed: make(chan struct{}),
+	}
+
+	// the server may send some frames right after the handshake
+	rc.playServerFrames()
+
+	return rc
+}
+
+// Read is an implementation of io.Reader, it returns the recorded server frames.
+func (rc *replayConn) Read(p []byte) (int, error) {
+	for len(rc.pending) == 0 {
+		select {
+		case chunk, ok := <-rc.out:
+			if !ok {
+				return 0, io.EOF
+			}
+
+			if err := delay(rc.ctx, rc.delayFunc(chunk.Delay)); err != nil {
+				return 0, err
+			}
+
+			rc.pending = chunk.Data
+		case <-rc.closed:
+			return 0, io.EOF
+		case <-rc.ctx.Done():
+			return 0, rc.ctx.Err()
+		}
+	}
+
+	n := copy(p, rc.pending)
+	rc.pending = rc.pending[n:]
+
+	return n, nil
+}
+
+// Write is an implementation of io.Writer, it consumes the client frames.
+func (rc *replayConn) Write(p []byte) (int, error) {
+	select {
+	case <-rc.closed:
+		return 0, errConnClosed
+	default:
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for _, frame := range rc.client.write(p) {
+		rc.receive(frame)
+	}
+
+	return len(p), nil
+}
+
+// Close is an implementation of io.Closer.
+func (rc *replayConn) Close() error {
+	rc.closeOnce.Do(func() {
+		close(rc.closed)
+	})
+
+	return nil
+}
+
+// receive looks for the next recorded client frame that matches the received one
+// and plays back the server frames that follow it.
+func (rc *replayConn) receive(frame Frame) {
+	if rc.outClosed {
+		return
+	}
+
+	for i := rc.next; i < len(rc.frames); i++ {
+		if rc.frames[i].matches(frame) {
+			rc.next = i + 1
+			rc.playServerFrames()
+			return
+		}
+	}
+
+	if frame.Opcode == opcodeClose {
+		// the client closes the connection unexpectedly, echo the close frame
+		rc.out <- Chunk{Data: Frame{Fin: true, Opcode: opcodeClose, Payload: frame.Payload}.encode()}
+		rc.closeOutput()
+	}
+}
+
+// playServerFrames sends the server frames up to the next client frame.
+func (rc *replayConn) playServerFrames() {
+	for ; rc.next < len(rc.frames) && !rc.frames[rc.next].FromClient; rc.next++ {
+		frame := rc.frames[rc.next]
+		rc.out <- Chunk{Delay: frame.Delay, Data: frame.encode()}
+
+		if frame.Opcode == opcodeClose {
+			rc.closeOutput()
+			return
+		}
+	}
+}
+
+// closeOutput ends the stream of the server frames.
+func (rc *replayConn) closeOutput() {
+	rc.next = len(rc.frames)
+	rc.outClosed = true
+	close(rc.out)
+}
@@ -0,0 +1,385 @@
+package govcr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newWebSocketServer starts the server that accepts WebSocket connections,
+// greets the client and echoes the client frames back until the close frame.
+func newWebSocketServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			websocketAccept(r.Header.Get("Sec-WebSocket-Key")))
+		brw.Write(Frame{Fin: true, Opcode: 0x1, Payload: []byte("hello")}.encode())
+		brw.Flush()
+
+		var fp frameParser
+		buf := make([]byte, 1024)
+		for {
+			n, err := brw.Read(buf)
+			if err != nil {
+				return
+			}
+
+			for _, frame := range fp.write(buf[:n]) {
+				conn.Write(Frame{Fin: true, Opcode: frame.Opcode, Payload: frame.Payload}.encode())
+				if frame.Opcode == opcodeClose {
+					return
+				}
+			}
+		}
+	}))
+
+	t.Cleanup(server.Close)
+	return server
+}
+
+// dialWebSocket opens the WebSocket connection through the client.
+func dialWebSocket(t *testing.T, client *http.Client, url string) (io.ReadWriteCloser, *bufio.Reader) {
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected handshake response: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("response body is not writable: %T", resp.Body)
+	}
+
+	return conn, bufio.NewReader(conn)
+}
+
+// clientFrame returns the wire representation of the frame masked as the client sends it.
+func clientFrame(opcode byte, payload string) []byte {
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+
+	data := Frame{Fin: true, Opcode: opcode, Payload: []byte(payload)}.encode()
+	data[1] |= 0x80
+
+	offset := len(data) - len(payload)
+	masked := append(append(append([]byte{}, data[:offset]...), mask...), data[offset:]...)
+	for i := range payload {
+		masked[offset+4+i] ^= mask[i%4]
+	}
+
+	return masked
+}
+
+// readFrame reads the next server frame.
+func readFrame(t *testing.T, r *bufio.Reader) Frame {
+	var fp frameParser
+
+	buf := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			t.Fatalf("failed to read frame: %v", err)
+		}
+
+		if frames := fp.write(buf); len(frames) > 0 {
+			return frames[0]
+		}
+	}
+}
+
+func Test_decodeFrame(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	huge := strings.Repeat("b", 70000)
+
+	tests := []struct {
+		name      string
+		data      []byte
+		wantFrame Frame
+		wantN     int
+	}{
+		{name: "empty", data: nil},
+		{name: "incomplete header", data: []byte{0x81}},
+		{name: "incomplete payload", data: Frame{Fin: true, Opcode: 0x1, Payload: []byte("hello")}.encode()[:4]},
+		{
+			name:      "unmasked",
+			data:      Frame{Fin: true, Opcode: 0x1, Payload: []byte("hello")}.encode(),
+			wantFrame: Frame{Fin: true, Opcode: 0x1, Payload: []byte("hello")},
+			wantN:     7,
+		},
+		{
+			name:      "masked",
+			data:      clientFrame(0x2, "hello"),
+			wantFrame: Frame{Fin: true, Opcode: 0x2, Payload: []byte("hello")},
+			wantN:     11,
+		},
+		{
+			name:      "fragment with rsv bits",
+			data:      Frame{Rsv: 0x4, Opcode: 0x1, Payload: []byte("hel")}.encode(),
+			wantFrame: Frame{Rsv: 0x4, Opcode: 0x1, Payload: []byte("hel")},
+			wantN:     5,
+		},
+		{
+			name:      "16 bit length",
+			data:      clientFrame(0x1, long),
+			wantFrame: Frame{Fin: true, Opcode: 0x1, Payload: []byte(long)},
+			wantN:     2 + 2 + 4 + 300,
+		},
+		{name: "incomplete 16 bit length", data: clientFrame(0x1, long)[:3]},
+		{
+			name:      "64 bit length",
+			data:      clientFrame(0x1, huge),
+			wantFrame: Frame{Fin: true, Opcode: 0x1, Payload: []byte(huge)},
+			wantN:     2 + 8 + 4 + 70000,
+		},
+		{name: "incomplete 64 bit length", data: clientFrame(0x1, huge)[:9]},
+		{name: "incomplete mask", data: clientFrame(0x1, "hello")[:5]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, n := decodeFrame(tt.data)
+			if n != tt.wantN {
+				t.Fatalf("decodeFrame consumed %d bytes, want: %d", n, tt.wantN)
+			}
+
+			if frame.Fin != tt.wantFrame.Fin || frame.Rsv != tt.wantFrame.Rsv || frame.Opcode != tt.wantFrame.Opcode ||
+				!bytes.Equal(frame.Payload, tt.wantFrame.Payload) {
+				t.Errorf("decodeFrame = %+v, want: %+v", frame, tt.wantFrame)
+			}
+
+			// the server frames are encoded back unmasked
+			if n > 0 && !bytes.Equal(frame.encode(), Frame{Fin: frame.Fin, Rsv: frame.Rsv, Opcode: frame.Opcode, Payload: tt.wantFrame.Payload}.encode()) {
+				t.Errorf("encoded frame doesn't round trip")
+			}
+		})
+	}
+}
+
+func TestFrame_encode(t *testing.T) {
+	tests := []struct {
+		name       string
+		frame      Frame
+		wantHeader []byte
+	}{
+		{name: "short", frame: Frame{Fin: true, Opcode: 0x1, Payload: make([]byte, 125)}, wantHeader: []byte{0x81, 125}},
+		{name: "16 bit length", frame: Frame{Fin: true, Opcode: 0x2, Payload: make([]byte, 126)}, wantHeader: []byte{0x82, 126, 0x00, 126}},
+		{name: "16 bit max", frame: Frame{Opcode: 0x0, Payload: make([]byte, 0xffff)}, wantHeader: []byte{0x00, 126, 0xff, 0xff}},
+		{name: "64 bit length", frame: Frame{Fin: true, Rsv: 0x4, Opcode: 0x2, Payload: make([]byte, 0x10000)}, wantHeader: []byte{0xc2, 127, 0, 0, 0, 0, 0, 0x01, 0x00, 0x00}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.frame.encode()
+			if !bytes.HasPrefix(data, tt.wantHeader) || len(data) != len(tt.wantHeader)+len(tt.frame.Payload) {
+				t.Errorf("encode header = % x, length %d, want: % x", data[:len(tt.wantHeader)], len(data), tt.wantHeader)
+			}
+		})
+	}
+}
+
+func Test_frameParser_write(t *testing.T) {
+	data := append(clientFrame(0x1, "first"), clientFrame(0x1, strings.Repeat("s", 200))...)
+	data = append(data, clientFrame(opcodeClose, "")...)
+
+	tests := []struct {
+		name  string
+		split int
+	}{
+		{name: "single write", split: len(data)},
+		{name: "byte by byte", split: 1},
+		{name: "split headers", split: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				fp     frameParser
+				frames []Frame
+			)
+
+			for i := 0; i < len(data); i += tt.split {
+				end := i + tt.split
+				if end > len(data) {
+					end = len(data)
+				}
+				frames = append(frames, fp.write(data[i:end])...)
+			}
+
+			if len(frames) != 3 || string(frames[0].Payload) != "first" || len(frames[1].Payload) != 200 || frames[2].Opcode != opcodeClose {
+				t.Errorf("unexpected frames: %+v", frames)
+			}
+
+			if len(fp.buf) != 0 {
+				t.Errorf("unconsumed bytes: %d", len(fp.buf))
+			}
+		})
+	}
+}
+
+func Test_websocketAccept(t *testing.T) {
+	// the example from RFC 6455
+	if got := websocketAccept("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("websocketAccept = %q", got)
+	}
+}
+
+func Test_replayConn(t *testing.T) {
+	frames := []Frame{
+		{Fin: true, Opcode: 0x1, Payload: []byte("hello")},
+		{Fin: true, Opcode: 0x1, Payload: []byte("ping"), FromClient: true},
+		{Fin: true, Opcode: 0x1, Payload: []byte("pong")},
+		{Fin: true, Opcode: opcodeClose, Payload: []byte{0x03, 0xe8}, FromClient: true},
+		{Fin: true, Opcode: opcodeClose, Payload: []byte{0x03, 0xe8}},
+	}
+
+	tests := []struct {
+		name   string
+		frames []Frame
+		writes [][]byte
+		want   []string
+	}{
+		{
+			name:   "matching frames",
+			frames: frames,
+			writes: [][]byte{clientFrame(0x1, "ping"), clientFrame(opcodeClose, "\x03\xe8")},
+			want:   []string{"hello", "pong", "\x03\xe8"},
+		},
+		{
+			name:   "unmatched frame is ignored",
+			frames: frames,
+			writes: [][]byte{clientFrame(0x1, "other"), clientFrame(0x1, "ping"), clientFrame(opcodeClose, "\x03\xe8")},
+			want:   []string{"hello", "pong", "\x03\xe8"},
+		},
+		{
+			name:   "unexpected close frame is echoed",
+			frames: frames[:3],
+			writes: [][]byte{clientFrame(0x1, "ping"), clientFrame(opcodeClose, "\x03\xe9")},
+			want:   []string{"hello", "pong", "\x03\xe9"},
+		},
+		{
+			name:   "close before the recorded frames",
+			frames: frames,
+			writes: [][]byte{clientFrame(opcodeClose, "\x03\xe8")},
+			want:   []string{"hello", "\x03\xe8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newReplayConn(context.Background(), tt.frames, func(time.Duration) time.Duration { return 0 })
+			defer rc.Close()
+
+			for _, data := range tt.writes {
+				if _, err := rc.Write(data); err != nil {
+					t.Fatalf("failed to write: %v", err)
+				}
+			}
+
+			// the stream of the server frames ends with the close frame
+			r := bufio.NewReader(rc)
+
+			var got []string
+			for range tt.want {
+				got = append(got, string(readFrame(t, r).Payload))
+			}
+
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("played back frames = %q, want: %q", got, tt.want)
+			}
+
+			if _, err := r.ReadByte(); err != io.EOF {
+				t.Errorf("unexpected error after the close frame: %v", err)
+			}
+		})
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		rc := newReplayConn(context.Background(), nil, func(time.Duration) time.Duration { return 0 })
+		rc.Close()
+
+		if _, err := rc.Write(clientFrame(0x1, "ping")); err != errConnClosed {
+			t.Errorf("unexpected write error: %v", err)
+		}
+
+		if _, err := rc.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("unexpected read error: %v", err)
+		}
+	})
+}
+
+func Test_vcrTransport_RoundTrip_websocket(t *testing.T) {
+	server := newWebSocketServer(t)
+	dir := t.TempDir()
+
+	// record the session: the greeting, the echo and the closing handshake
+	recorder := NewVCR("ws", &VCRConfig{CassettePath: dir})
+
+	conn, r := dialWebSocket(t, recorder.Client, server.URL)
+	readFrame(t, r)
+
+	conn.Write(clientFrame(0x1, "ping"))
+	readFrame(t, r)
+
+	conn.Write(clientFrame(opcodeClose, "\x03\xe8"))
+	if f := readFrame(t, r); f.Opcode != opcodeClose {
+		t.Fatalf("unexpected close reply: %+v", f)
+	}
+	conn.Close()
+
+	k7, err := readCassetteFromFile("ws", dir)
+	if err != nil {
+		t.Fatalf("failed to read cassette: %v", err)
+	}
+
+	if len(k7.Tracks) != 1 || len(k7.Tracks[0].Response.Frames) != 5 {
+		t.Fatalf("unexpected tracks: %+v", k7.Tracks)
+	}
+
+	// play it back, the handshake is answered with the accept key of the new client key
+	player := NewVCR("ws", &VCRConfig{CassettePath: dir, DisableRecording: true})
+
+	conn, r = dialWebSocket(t, player.Client, server.URL)
+	defer conn.Close()
+
+	if f := readFrame(t, r); string(f.Payload) != "hello" {
+		t.Errorf("unexpected greeting: %q", f.Payload)
+	}
+
+	conn.Write(clientFrame(0x1, "ping"))
+	if f := readFrame(t, r); string(f.Payload) != "ping" {
+		t.Errorf("unexpected echo: %q", f.Payload)
+	}
+
+	conn.Write(clientFrame(opcodeClose, "\x03\xe8"))
+	if f := readFrame(t, r); f.Opcode != opcodeClose || string(f.Payload) != "\x03\xe8" {
+		t.Errorf("unexpected close reply: %+v", f)
+	}
+
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("connection is not closed after the close frame: %v", err)
+	}
+
+	if stats := player.Stats(); stats.TracksPlayed != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,484 @@
+package gmeter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+const (
+	//maxJournalEntries is the number of the latest exchanges kept in the journal
+	maxJournalEntries = 1000
+
+	//maxCapturedBody is the number of bytes of the request and response bodies kept in the journal
+	maxCapturedBody = 64 << 10
+)
+
+type (
+	//journal keeps the latest exchanges and notifies the subscribers
+	//about the new ones, the zero value is ready to use
+	journal struct {
+		lock        sync.Mutex
+		entries     []*entry
+		lastID      uint64
+		subscribers map[chan *entry]struct{}
+
+		//closed is set on shutdown, the subscribers get closed channels
+		closed bool
+	}
+
+	//entry is an exchange in the journal, it's added to the journal
+	//once the response body is read to the end or closed
+	entry struct {
+		ID       uint64    `json:"id"`
+		Time     time.Time `json:"time"`
+		Session  string    `json:"session"`
+		Mode     string    `json:"mode"`
+		Cassette string    `json:"cassette"`
+		Result   string    `json:"result"`
+
+		//Track is the number of the played back track
+		Track *int `json:"track,omitempty"`
+
+		//Duration is the time until the response body is read
+		Duration string   `json:"duration"`
+		Error    string   `json:"error,omitempty"`
+		Request  message  `json:"request"`
+		Response *message `json:"response,omitempty"`
+	}
+
+	//message is the request or the response of the exchange
+	message struct {
+		Method string      `json:"method,omitempty"`
+		URL    string      `json:"url,omitempty"`
+		Status int         `json:"status,omitempty"`
+		Header http.Header `json:"header"`
+		Body   string      `json:"body"`
+
+		//Encoding is base64 if the body is not a valid UTF-8 string
+		Encoding string `json:"encoding,omitempty"`
+
+		//Truncated is set if only the first maxCapturedBody bytes of the body are kept
+		Truncated bool `json:"truncated,omitempty"`
+	}
+
+	//capture keeps the first maxCapturedBody bytes read from the body
+	//and calls done once the body is read to the end or closed
+	capture struct {
+		io.ReadCloser
+
+		lock      sync.Mutex
+		data      []byte
+		truncated bool
+		done      func()
+		once      sync.Once
+	}
+)
+
+//add assigns the ID to the entry and notifies the subscribers
+func (j *journal) add(e *entry) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.lastID++
+	e.ID = j.lastID
+
+	j.entries = append(j.entries, e)
+	if len(j.entries) > maxJournalEntries {
+		j.entries = append([]*entry(nil), j.entries[len(j.entries)-maxJournalEntries:]...)
+	}
+
+	for ch := range j.subscribers {
+		select {
+		case ch <- e:
+		default:
+			//slow subscribers miss the entries rather than block the exchanges
+		}
+	}
+}
+
+//since returns the entries that were added after the entry with the ID
+func (j *journal) since(id uint64) []*entry {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	var entries []*entry
+	for _, e := range j.entries {
+		if e.ID > id {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+//clear removes all the entries, the IDs of the new entries keep growing
+func (j *journal) clear() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	j.entries = nil
+}
+
+//close closes the channels of the subscribers so that the long polling
+//and streaming requests end and don't hold the server on shutdown
+func (j *journal) close() {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	for ch := range j.subscribers {
+		close(ch)
+	}
+
+	j.subscribers = nil
+	j.closed = true
+}
+
+//subscribe returns the channel of the new entries and the function to unsubscribe
+func (j *journal) subscribe() (chan *entry, func()) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if j.subscribers == nil {
+		j.subscribers = map[chan *entry]struct{}{}
+	}
+
+	ch := make(chan *entry, 100)
+	if j.closed {
+		close(ch)
+	} else {
+		j.subscribers[ch] = struct{}{}
+	}
+
+	return ch, func() {
+		j.lock.Lock()
+		defer j.lock.Unlock()
+
+		delete(j.subscribers, ch)
+	}
+}
+
+func newCapture(body io.ReadCloser, done func()) *capture {
+	return &capture{ReadCloser: body, done: done}
+}
+
+func (c *capture) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+
+	c.lock.Lock()
+	kept := min(n, maxCapturedBody-len(c.data))
+	c.data = append(c.data, p[:kept]...)
+	c.truncated = c.truncated || kept < n
+	c.lock.Unlock()
+
+	if err == io.EOF {
+		c.finish()
+	}
+
+	return n, err
+}
+
+func (c *capture) Close() error {
+	err := c.ReadCloser.Close()
+	c.finish()
+
+	return err
+}
+
+func (c *capture) finish() {
+	c.once.Do(c.done)
+}
+
+//message sets the captured body of the message
+func (c *capture) message(m *message) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	m.Truncated = c.truncated
+	if utf8.Valid(c.data) {
+		m.Body = string(c.data)
+		return
+	}
+
+	m.Body, m.Encoding = base64.StdEncoding.EncodeToString(c.data), "base64"
+}
+
+//journalExchange adds the exchange to the journal, if the response has a body the
+//exchange is added once the body is read, the returned response should be used instead
+func (rt *RoundTripper) journalExchange(r *http.Request, resp *http.Response, err error, ex *exchange, start time.Time, reqBody *capture) *http.Response {
+	e := &entry{
+		Time:     start,
+		Session:  ex.session,
+		Mode:     ex.mode,
+		Cassette: ex.cassette,
+		Result:   ex.result(),
+		Request:  message{Method: r.Method, URL: r.URL.String(), Header: r.Header.Clone()},
+	}
+
+	if e.Result == govcr.ResultPlayed {
+		track := ex.outcome.Track
+		e.Track = &track
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	add := func(respBody *capture) {
+		reqBody.message(&e.Request)
+
+		if resp != nil {
+			e.Response = &message{Status: resp.StatusCode, Header: resp.Header.Clone()}
+			respBody.message(e.Response)
+		}
+
+		e.Duration = time.Since(start).String()
+		rt.journal.add(e)
+	}
+
+	//the body of the switched protocol responses is the connection itself
+	if resp == nil || resp.Body == nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		add(nil)
+		return resp
+	}
+
+	var respBody *capture
+	respBody = newCapture(resp.Body, func() {
+		add(respBody)
+	})
+
+	resp.Body = respBody
+
+	return resp
+}
+
+//exchangeFilter selects the exchanges by the query parameters, empty fields match any value
+type exchangeFilter struct {
+	session  string
+	cassette string
+	method   string
+	result   string
+	status   int
+	path     *regexp.Regexp
+}
+
+//newExchangeFilter returns the filter for the query parameters, the current
+//session is used if the session parameter is "current"
+func newExchangeFilter(query url.Values, currentSession string) (*exchangeFilter, error) {
+	f := &exchangeFilter{
+		session:  query.Get("session"),
+		cassette: query.Get("cassette"),
+		method:   query.Get("method"),
+		result:   query.Get("result"),
+	}
+
+	if f.session == "current" {
+		f.session = currentSession
+	}
+
+	if status := query.Get("status"); status != "" {
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status: %q", status)
+		}
+		f.status = code
+	}
+
+	if path := query.Get("path"); path != "" {
+		re, err := regexp.Compile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path regexp: %v", err)
+		}
+		f.path = re
+	}
+
+	return f, nil
+}
+
+func (f *exchangeFilter) matches(e *entry) bool {
+	if (f.session != "" && f.session != e.Session) ||
+		(f.cassette != "" && f.cassette != e.Cassette) ||
+		(f.method != "" && !strings.EqualFold(f.method, e.Request.Method)) ||
+		(f.result != "" && f.result != e.Result) {
+		return false
+	}
+
+	if f.status != 0 && (e.Response == nil || e.Response.Status != f.status) {
+		return false
+	}
+
+	if f.path != nil {
+		u, err := url.Parse(e.Request.URL)
+		return err == nil && f.path.MatchString(u.Path)
+	}
+
+	return true
+}
+
+//filter returns the entries that match the filter
+func (f *exchangeFilter) filter(entries []*entry) []*entry {
+	matched := []*entry{}
+	for _, e := range entries {
+		if f.matches(e) {
+			matched = append(matched, e)
+		}
+	}
+
+	return matched
+}
+
+//Exchanges returns (GET) the exchanges from the journal or clears (DELETE) the journal.
+//The exchanges are streamed as server-sent events if the client accepts text/event-stream,
+//otherwise they are returned as a JSON array. The exchanges can be filtered by the session,
+//cassette, method, path (regexp), result and status parameters, only the exchanges after the
+//since ID (or Last-Event-ID) are returned. With the wait parameter the request is held until
+//there are matching exchanges or the wait duration passes
+func (rt *RoundTripper) Exchanges(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		rt.journal.clear()
+		rt.logger.Info("cleared the journal")
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	rt.lock.RLock()
+	f, err := newExchangeFilter(query, rt.session)
+	rt.lock.RUnlock()
+
+	if err != nil {
+		rt.logger.Warn("invalid exchanges filter", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	since := query.Get("since")
+	if since == "" {
+		since = r.Header.Get("Last-Event-ID")
+	}
+
+	var lastID uint64
+	if since != "" {
+		if lastID, err = strconv.ParseUint(since, 10, 64); err != nil {
+			rt.logger.Warn("invalid exchanges since ID", "since", since)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	var wait time.Duration
+	if query.Get("wait") != "" {
+		if wait, err = time.ParseDuration(query.Get("wait")); err != nil {
+			rt.logger.Warn("invalid exchanges wait duration", "wait", query.Get("wait"))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	//subscribe before reading the journal so no entries are lost in between
+	ch, unsubscribe := rt.journal.subscribe()
+	defer unsubscribe()
+
+	entries := f.filter(rt.journal.since(lastID))
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		streamExchanges(w, r, f, entries, ch)
+		return
+	}
+
+	if len(entries) == 0 && wait > 0 {
+		entries = waitExchanges(r, f, lastID, ch, wait)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+//waitExchanges waits for the first entry after lastID that matches the filter
+func waitExchanges(r *http.Request, f *exchangeFilter, lastID uint64, ch chan *entry, wait time.Duration) []*entry {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return []*entry{}
+			}
+
+			if e.ID > lastID && f.matches(e) {
+				return []*entry{e}
+			}
+		case <-timer.C:
+			return []*entry{}
+		case <-r.Context().Done():
+			return []*entry{}
+		}
+	}
+}
+
+//streamExchanges sends the entries and then the new entries that match the filter as server-sent events
+func streamExchanges(w http.ResponseWriter, r *http.Request, f *exchangeFilter, entries []*entry, ch chan *entry) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+
+	var lastID uint64
+	send := func(e *entry) bool {
+		if e.ID <= lastID || !f.matches(e) {
+			return true
+		}
+		lastID = e.ID
+
+		data, err := json.Marshal(e)
+		if err != nil {
+			return false
+		}
+
+		if _, err := fmt.Fprintf(w, "id: %d\ndata: %s\n\n", e.ID, data); err != nil {
+			return false
+		}
+
+		return rc.Flush() == nil
+	}
+
+	for _, e := range entries {
+		if !send(e) {
+			return
+		}
+	}
+	rc.Flush()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok || !send(e) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
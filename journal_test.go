@@ -0,0 +1,276 @@
+package gmeter
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_capture(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		wantBody      string
+		wantEncoding  string
+		wantTruncated bool
+	}{
+		{name: "text", body: "hello", wantBody: "hello"},
+		{name: "binary", body: "\xff\xfe", wantBody: "//4=", wantEncoding: "base64"},
+		{name: "truncated", body: strings.Repeat("a", maxCapturedBody+1), wantBody: strings.Repeat("a", maxCapturedBody), wantTruncated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := 0
+			c := newCapture(ioutil.NopCloser(strings.NewReader(tt.body)), func() { done++ })
+
+			data, err := ioutil.ReadAll(c)
+			if err != nil || string(data) != tt.body {
+				t.Fatalf("unexpected read result: %v", err)
+			}
+			c.Close()
+
+			if done != 1 {
+				t.Errorf("done called %d times", done)
+			}
+
+			var m message
+			c.message(&m)
+			if m.Body != tt.wantBody || m.Encoding != tt.wantEncoding || m.Truncated != tt.wantTruncated {
+				t.Errorf("unexpected message: %q %q %t", m.Body, m.Encoding, m.Truncated)
+			}
+		})
+	}
+}
+
+func Test_journal_add(t *testing.T) {
+	var j journal
+	for i := 0; i < maxJournalEntries+10; i++ {
+		j.add(&entry{})
+	}
+
+	entries := j.since(0)
+	if len(entries) != maxJournalEntries || entries[0].ID != 11 {
+		t.Errorf("unexpected entries: %d, first: %d", len(entries), entries[0].ID)
+	}
+
+	if got := j.since(maxJournalEntries + 8); len(got) != 2 {
+		t.Errorf("unexpected number of entries since: %d", len(got))
+	}
+}
+
+func TestRoundTripper_Exchanges(t *testing.T) {
+	rt := &RoundTripper{
+		RoundTripper: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			body, _ := ioutil.ReadAll(r.Body)
+			return &http.Response{StatusCode: http.StatusCreated, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader("echo: " + string(body)))}, nil
+		}),
+		logger: slog.New(slog.DiscardHandler),
+		mode:   modePassthrough,
+	}
+
+	exchange := func(body string) {
+		resp, err := rt.RoundTrip(httptest.NewRequest("POST", "http://example.com/users", strings.NewReader(body)))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	exchange("first")
+
+	server := httptest.NewServer(http.HandlerFunc(rt.Exchanges))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("unexpected content type: %s", resp.Header.Get("Content-Type"))
+	}
+
+	events := make(chan entry)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data := strings.TrimPrefix(scanner.Text(), "data: "); data != scanner.Text() {
+				var e entry
+				json.Unmarshal([]byte(data), &e)
+				events <- e
+			}
+		}
+	}()
+
+	next := func() entry {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("no event")
+		}
+		return entry{}
+	}
+
+	if e := next(); e.ID != 1 || e.Request.Body != "first" || e.Response.Body != "echo: first" {
+		t.Errorf("unexpected first event: %+v", e)
+	}
+
+	exchange("second")
+
+	e := next()
+	if e.ID != 2 || e.Result != resultPassthrough || e.Request.Method != "POST" || e.Request.URL != "http://example.com/users" ||
+		e.Request.Body != "second" || e.Response.Status != http.StatusCreated || e.Response.Body != "echo: second" {
+		t.Errorf("unexpected second event: %+v", e)
+	}
+}
+
+func Test_exchangeFilter_matches(t *testing.T) {
+	e := &entry{
+		Session:  "s1",
+		Cassette: "users",
+		Result:   "played",
+		Request:  message{Method: "GET", URL: "http://example.com/users/1?x=y"},
+		Response: &message{Status: http.StatusOK},
+	}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    bool
+		wantErr bool
+	}{
+		{name: "no filter", query: "", want: true},
+		{name: "current session", query: "session=current", want: true},
+		{name: "other session", query: "session=s2", want: false},
+		{name: "all fields", query: "cassette=users&method=get&result=played&status=200&path=^/users/[0-9]%2B$", want: true},
+		{name: "other path", query: "path=^/orders", want: false},
+		{name: "other status", query: "status=404", want: false},
+		{name: "bad status", query: "status=ok", wantErr: true},
+		{name: "bad path", query: "path=(", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+
+			f, err := newExchangeFilter(query, "s1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newExchangeFilter error = %v, wantErr: %t", err, tt.wantErr)
+			}
+
+			if err == nil && f.matches(e) != tt.want {
+				t.Errorf("matches got: %t, want: %t", !tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_Exchanges_list(t *testing.T) {
+	rt := &RoundTripper{
+		RoundTripper: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}, nil
+		}),
+		logger: slog.New(slog.DiscardHandler),
+		mode:   modePassthrough,
+	}
+
+	exchange := func(method, path string) {
+		resp, _ := rt.RoundTrip(httptest.NewRequest(method, "http://example.com"+path, nil))
+		resp.Body.Close()
+	}
+
+	list := func(method, query string) (int, []entry) {
+		w := httptest.NewRecorder()
+		rt.Exchanges(w, httptest.NewRequest(method, "/gmeter/exchanges?"+query, nil))
+
+		var entries []entry
+		json.Unmarshal(w.Body.Bytes(), &entries)
+		return w.Code, entries
+	}
+
+	exchange("GET", "/users")
+	exchange("POST", "/users")
+	exchange("GET", "/orders")
+
+	tests := []struct {
+		name     string
+		query    string
+		wantCode int
+		wantIDs  []uint64
+	}{
+		{name: "all", wantCode: http.StatusOK, wantIDs: []uint64{1, 2, 3}},
+		{name: "filtered", query: "method=GET&path=^/users", wantCode: http.StatusOK, wantIDs: []uint64{1}},
+		{name: "since", query: "since=1", wantCode: http.StatusOK, wantIDs: []uint64{2, 3}},
+		{name: "bad since", query: "since=first", wantCode: http.StatusBadRequest},
+		{name: "bad wait", query: "wait=forever", wantCode: http.StatusBadRequest},
+		{name: "bad filter", query: "status=ok", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, entries := list("GET", tt.query)
+			if code != tt.wantCode {
+				t.Fatalf("unexpected status code: %d", code)
+			}
+
+			var ids []uint64
+			for _, e := range entries {
+				ids = append(ids, e.ID)
+			}
+
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("got IDs: %v, want: %v", ids, tt.wantIDs)
+			}
+		})
+	}
+
+	t.Run("wait", func(t *testing.T) {
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			exchange("GET", "/orders")
+			exchange("DELETE", "/users")
+		}()
+
+		_, entries := list("GET", "since=3&method=DELETE&wait=5s")
+		if len(entries) != 1 || entries[0].ID != 5 {
+			t.Errorf("unexpected entries: %+v", entries)
+		}
+
+		start := time.Now()
+		if _, entries := list("GET", "since=5&wait=50ms"); len(entries) != 0 || time.Since(start) < 50*time.Millisecond {
+			t.Errorf("unexpected entries: %+v", entries)
+		}
+	})
+
+	t.Run("clear", func(t *testing.T) {
+		if code, _ := list("DELETE", ""); code != http.StatusOK {
+			t.Errorf("unexpected status code: %d", code)
+		}
+
+		exchange("GET", "/users")
+
+		if _, entries := list("GET", ""); len(entries) != 1 || entries[0].ID != 6 {
+			t.Errorf("unexpected entries after clear: %+v", entries)
+		}
+	})
+
+	if code, _ := list("PUT", ""); code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status code: %d", code)
+	}
+}
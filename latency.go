@@ -0,0 +1,113 @@
+package gmeter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+const (
+	latencyRecorded = "recorded"
+	latencyFixed    = "fixed"
+	latencyRandom   = "random"
+)
+
+type (
+	//latency describes how played back responses are delayed:
+	//recorded - latency of the live server multiplied by the Scale,
+	//fixed - every response is delayed by Min,
+	//random - every response is delayed by a random value in [Min, Max)
+	latency struct {
+		Mode  string   `json:"mode"`
+		Scale float64  `json:"scale"`
+		Min   duration `json:"min"`
+		Max   duration `json:"max"`
+	}
+
+	//duration is a time.Duration that is (un)marshaled as strings like "150ms"
+	duration time.Duration
+)
+
+//MarshalJSON implements json.Marshaler
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+//UnmarshalJSON implements json.Unmarshaler
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration should be a string: %v", err)
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = duration(parsed)
+	return nil
+}
+
+func (l latency) validate() error {
+	switch l.Mode {
+	case latencyRecorded:
+		if l.Scale < 0 {
+			return errors.New("latency scale can't be negative")
+		}
+	case latencyFixed:
+		if l.Min < 0 {
+			return errors.New("latency can't be negative")
+		}
+	case latencyRandom:
+		if l.Min < 0 || l.Max <= l.Min {
+			return errors.New("latency range should be non-negative and max should be greater than min")
+		}
+	default:
+		return fmt.Errorf("unsupported latency mode: %q", l.Mode)
+	}
+
+	return nil
+}
+
+//chunkDelay implements govcr.ChunkDelayFunc, streamed responses are played back
+//at the scaled recorded pace in the recorded mode and without delays otherwise
+func (l latency) chunkDelay(recorded time.Duration) time.Duration {
+	if l.Mode != latencyRecorded {
+		return 0
+	}
+
+	scale := l.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	return time.Duration(float64(recorded) * scale)
+}
+
+//timing implements govcr.LatencyFunc
+func (l latency) timing(recorded govcr.Timing) govcr.Timing {
+	switch l.Mode {
+	case latencyRecorded:
+		scale := l.Scale
+		if scale == 0 {
+			scale = 1
+		}
+
+		return govcr.Timing{
+			FirstByte: time.Duration(float64(recorded.FirstByte) * scale),
+			Total:     time.Duration(float64(recorded.Total) * scale),
+		}
+	case latencyFixed:
+		return govcr.Timing{FirstByte: time.Duration(l.Min), Total: time.Duration(l.Min)}
+	case latencyRandom:
+		d := time.Duration(l.Min) + time.Duration(rand.Int63n(int64(l.Max-l.Min)))
+		return govcr.Timing{FirstByte: d, Total: d}
+	}
+
+	return govcr.Timing{}
+}
@@ -0,0 +1,152 @@
+package gmeter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+func Test_duration_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+
+		want1   duration
+		wantErr bool
+	}{
+		{
+			name:    "not a string",
+			data:    `100`,
+			wantErr: true,
+		},
+		{
+			name:    "bad duration",
+			data:    `"100 bananas"`,
+			wantErr: true,
+		},
+		{
+			name:  "success",
+			data:  `"150ms"`,
+			want1: duration(150 * time.Millisecond),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got1 duration
+			err := json.Unmarshal([]byte(tt.data), &got1)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("duration.UnmarshalJSON error = %v, wantErr: %t", err, tt.wantErr)
+			}
+
+			if got1 != tt.want1 {
+				t.Errorf("duration.UnmarshalJSON got1 = %v, want1: %v", got1, tt.want1)
+			}
+		})
+	}
+}
+
+func Test_latency_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		latency latency
+		wantErr bool
+	}{
+		{name: "unsupported mode", latency: latency{Mode: "slow"}, wantErr: true},
+		{name: "negative scale", latency: latency{Mode: latencyRecorded, Scale: -1}, wantErr: true},
+		{name: "negative delay", latency: latency{Mode: latencyFixed, Min: -1}, wantErr: true},
+		{name: "empty range", latency: latency{Mode: latencyRandom, Min: 10, Max: 10}, wantErr: true},
+		{name: "recorded", latency: latency{Mode: latencyRecorded}},
+		{name: "fixed", latency: latency{Mode: latencyFixed, Min: 10}},
+		{name: "random", latency: latency{Mode: latencyRandom, Min: 10, Max: 20}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.latency.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("latency.validate error = %v, wantErr: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_latency_timing(t *testing.T) {
+	recorded := govcr.Timing{FirstByte: 100 * time.Millisecond, Total: 300 * time.Millisecond}
+
+	tests := []struct {
+		name    string
+		latency latency
+		inspect func(got govcr.Timing, t *testing.T)
+	}{
+		{
+			name:    "recorded",
+			latency: latency{Mode: latencyRecorded},
+			inspect: func(got govcr.Timing, t *testing.T) {
+				if got != recorded {
+					t.Errorf("got: %v, want: %v", got, recorded)
+				}
+			},
+		},
+		{
+			name:    "scaled",
+			latency: latency{Mode: latencyRecorded, Scale: 0.5},
+			inspect: func(got govcr.Timing, t *testing.T) {
+				want := govcr.Timing{FirstByte: 50 * time.Millisecond, Total: 150 * time.Millisecond}
+				if got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+			},
+		},
+		{
+			name:    "fixed",
+			latency: latency{Mode: latencyFixed, Min: duration(time.Second)},
+			inspect: func(got govcr.Timing, t *testing.T) {
+				want := govcr.Timing{FirstByte: time.Second, Total: time.Second}
+				if got != want {
+					t.Errorf("got: %v, want: %v", got, want)
+				}
+			},
+		},
+		{
+			name:    "random",
+			latency: latency{Mode: latencyRandom, Min: duration(time.Second), Max: duration(2 * time.Second)},
+			inspect: func(got govcr.Timing, t *testing.T) {
+				if got.FirstByte < time.Second || got.FirstByte >= 2*time.Second || got.Total != got.FirstByte {
+					t.Errorf("unexpected timing: %v", got)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.inspect(tt.latency.timing(recorded), t)
+		})
+	}
+}
+
+func Test_latency_chunkDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		latency latency
+		want    time.Duration
+	}{
+		{name: "recorded", latency: latency{Mode: latencyRecorded}, want: time.Second},
+		{name: "scaled", latency: latency{Mode: latencyRecorded, Scale: 2}, want: 2 * time.Second},
+		{name: "fixed", latency: latency{Mode: latencyFixed, Min: duration(time.Millisecond)}, want: 0},
+		{name: "random", latency: latency{Mode: latencyRandom, Min: duration(time.Millisecond), Max: duration(time.Second)}, want: 0},
+		{name: "no latency", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.latency.chunkDelay(time.Second); got != tt.want {
+				t.Errorf("latency.chunkDelay got: %v, want: %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package gmeter
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"time"
+)
+
+const selfSignedValidity = 365 * 24 * time.Hour
+
+//Listen opens a socket on the options.ListenAddress, when TLS is enabled
+//returned listener serves HTTPs with HTTP/2 support
+func Listen(options Options) (net.Listener, error) {
+	listener, err := net.Listen("tcp", options.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if !options.TLS {
+		return listener, nil
+	}
+
+	config, err := serverTLSConfig(options)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	return tls.NewListener(listener, config), nil
+}
+
+func serverTLSConfig(options Options) (*tls.Config, error) {
+	var (
+		cert tls.Certificate
+		err  error
+	)
+
+	if options.TLSCertFile != "" {
+		cert, err = tls.LoadX509KeyPair(options.TLSCertFile, options.TLSKeyFile)
+	} else {
+		cert, err = selfSignedCertificate(options.ListenAddress)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}, nil
+}
+
+//selfSignedCertificate generates a certificate that is valid for the localhost
+//and for the host part of the listen address
+func selfSignedCertificate(address string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate key: %v", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gmeter"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	if host, _, err := net.SplitHostPort(address); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if host != "localhost" {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
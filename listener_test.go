@@ -0,0 +1,111 @@
+package gmeter
+
+import (
+	"crypto/x509"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+
+		wantTLS    bool
+		wantErr    bool
+		inspectErr func(err error, t *testing.T) //use for more precise error evaluation after test
+	}{
+		{
+			name:    "bad address",
+			options: Options{ListenAddress: "bad address"},
+			wantErr: true,
+		},
+		{
+			name:    "plain",
+			options: Options{ListenAddress: "localhost:0"},
+		},
+		{
+			name:    "self-signed",
+			options: Options{ListenAddress: "localhost:0", TLS: true},
+			wantTLS: true,
+		},
+		{
+			name:    "missing certificate",
+			options: Options{ListenAddress: "localhost:0", TLS: true, TLSCertFile: "missing.crt", TLSKeyFile: "missing.key"},
+			wantErr: true,
+			inspectErr: func(err error, t *testing.T) {
+				if !strings.Contains(err.Error(), "failed to load TLS certificate") {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got1, err := Listen(tt.options)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Listen error = %v, wantErr: %t", err, tt.wantErr)
+			}
+
+			if tt.inspectErr != nil {
+				tt.inspectErr(err, t)
+			}
+
+			if err != nil {
+				return
+			}
+			defer got1.Close()
+
+			_, isPlain := got1.(*net.TCPListener)
+			if isPlain == tt.wantTLS {
+				t.Errorf("Listen returned %T, want TLS: %t", got1, tt.wantTLS)
+			}
+		})
+	}
+}
+
+func Test_selfSignedCertificate(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+
+		wantHost string
+	}{
+		{
+			name:     "localhost",
+			address:  "localhost:8080",
+			wantHost: "localhost",
+		},
+		{
+			name:     "ip address",
+			address:  "10.0.0.1:8080",
+			wantHost: "10.0.0.1",
+		},
+		{
+			name:     "host name",
+			address:  "gmeter.local:8080",
+			wantHost: "gmeter.local",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got1, err := selfSignedCertificate(tt.address)
+			if err != nil {
+				t.Fatalf("selfSignedCertificate error = %v", err)
+			}
+
+			cert, err := x509.ParseCertificate(got1.Certificate[0])
+			if err != nil {
+				t.Fatalf("failed to parse certificate: %v", err)
+			}
+
+			if err := cert.VerifyHostname(tt.wantHost); err != nil {
+				t.Errorf("certificate is not valid for %s: %v", tt.wantHost, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package gmeter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+//log formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+//results of the exchanges in addition to the ones reported by govcr
+const (
+	resultStub        = "stub"
+	resultFault       = "fault"
+	resultPassthrough = "passthrough"
+)
+
+type (
+	//exchange describes how the request was served, it's passed to
+	//the next round trippers in the context of the request
+	exchange struct {
+		outcome govcr.Outcome
+		stub    bool
+		fault   bool
+
+		//session, mode and cassette are copied from the RoundTripper when the
+		//exchange starts, the session can change while the exchange is served
+		session  string
+		mode     string
+		cassette string
+	}
+
+	exchangeKey struct{}
+)
+
+//NewLogger returns the logger that writes the entries to w in the format
+//(text for logfmt or json) with the minimum level, the level can be
+//changed later if it's *slog.LevelVar
+func NewLogger(format string, level slog.Leveler, w io.Writer) *slog.Logger {
+	handlerOptions := &slog.HandlerOptions{Level: level}
+
+	if format == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, handlerOptions))
+	}
+
+	return slog.New(slog.NewTextHandler(w, handlerOptions))
+}
+
+//newSessionID returns a random identifier of the record, play or passthrough session
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+//withExchange returns a shallow copy of the request with the exchange in its context
+func withExchange(r *http.Request, ex *exchange) *http.Request {
+	ctx := context.WithValue(govcr.WithOutcome(r.Context(), &ex.outcome), exchangeKey{}, ex)
+	return r.WithContext(ctx)
+}
+
+//exchangeFrom returns the exchange from the context of the request
+func exchangeFrom(r *http.Request) *exchange {
+	if ex, ok := r.Context().Value(exchangeKey{}).(*exchange); ok {
+		return ex
+	}
+
+	return &exchange{}
+}
+
+//result returns how the request was served in the mode
+func (ex *exchange) result() string {
+	switch {
+	case ex.stub:
+		return resultStub
+	case ex.outcome.Result != "":
+		return ex.outcome.Result
+	case ex.fault:
+		return resultFault
+	case ex.mode == modePassthrough:
+		return resultPassthrough
+	}
+
+	return ""
+}
+
+//logExchange writes the access log entry, misses are logged as warnings
+//and the other failures as errors
+func (rt *RoundTripper) logExchange(r *http.Request, resp *http.Response, err error, ex *exchange, duration time.Duration) {
+	result := ex.result()
+
+	attrs := []slog.Attr{
+		slog.String("session", ex.session),
+		slog.String("mode", ex.mode),
+		slog.String("cassette", ex.cassette),
+		slog.String("method", r.Method),
+		slog.String("url", r.URL.String()),
+		slog.String("result", result),
+	}
+
+	if result == govcr.ResultPlayed {
+		attrs = append(attrs, slog.Int("track", ex.outcome.Track))
+	}
+
+	if resp != nil {
+		attrs = append(attrs, slog.Int("status", resp.StatusCode))
+	}
+
+	attrs = append(attrs, slog.Duration("duration", duration))
+
+	level := slog.LevelInfo
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+
+		//played back errors were recorded on the cassette and
+		//the faults are injected on purpose
+		switch result {
+		case govcr.ResultMissed:
+			level = slog.LevelWarn
+		case govcr.ResultPlayed, resultFault:
+		default:
+			level = slog.LevelError
+		}
+	}
+
+	rt.logger.LogAttrs(r.Context(), level, "request", attrs...)
+}
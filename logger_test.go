@@ -0,0 +1,116 @@
+package gmeter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		want    string
+	}{
+		{name: "text", options: Options{LogFormat: logFormatText}, want: "level=INFO msg=hello key=value\n"},
+		{name: "json", options: Options{LogFormat: logFormatJSON}, want: `"level":"INFO","msg":"hello","key":"value"}` + "\n"},
+		{name: "level", options: Options{LogFormat: logFormatText, LogLevel: slog.LevelWarn}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			NewLogger(tt.options.LogFormat, tt.options.LogLevel, &buf).Info("hello", "key", "value")
+
+			if !strings.HasSuffix(buf.String(), tt.want) || (tt.want == "") != (buf.Len() == 0) {
+				t.Errorf("NewLogger got: %q, want suffix: %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_logExchange(t *testing.T) {
+	dir := writeCassette(t, "users", cassetteTrack("GET", "/users", "[]", "", "", ""))
+
+	var buf bytes.Buffer
+	rt := &RoundTripper{logger: NewLogger(logFormatJSON, slog.LevelInfo, &buf), options: Options{CassettePath: dir}}
+	rt.Play(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmeter/play", strings.NewReader(`{"cassette": "users"}`)))
+
+	for _, path := range []string{"/users", "/users"} {
+		rt.RoundTrip(httptest.NewRequest("GET", "http://example.com"+path, nil))
+	}
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to decode log entry %q: %v", line, err)
+		}
+
+		if entry["msg"] == "request" {
+			entries = append(entries, entry)
+		}
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("unexpected number of request entries: %d", len(entries))
+	}
+
+	played, missed := entries[0], entries[1]
+	if played["level"] != "INFO" || played["result"] != "played" || played["track"] != 0.0 || played["status"] != 200.0 ||
+		played["cassette"] != "users" || played["session"] != rt.session || played["session"] == "" {
+		t.Errorf("unexpected played entry: %v", played)
+	}
+
+	if _, ok := played["duration"]; !ok {
+		t.Errorf("played entry has no duration: %v", played)
+	}
+
+	if missed["level"] != "WARN" || missed["result"] != "missed" || missed["track"] != nil ||
+		!strings.HasPrefix(missed["error"].(string), "track not found") {
+		t.Errorf("unexpected missed entry: %v", missed)
+	}
+}
+
+func Test_exchange_result(t *testing.T) {
+	rt := &RoundTripper{mode: modePassthrough}
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+
+	tests := []struct {
+		name  string
+		ex    exchange
+		err   error
+		level string
+		want  string
+	}{
+		{name: "passthrough", want: "result=passthrough", level: "level=INFO"},
+		{name: "stub", ex: exchange{stub: true}, want: "result=stub", level: "level=INFO"},
+		{name: "fault", ex: exchange{fault: true}, err: errConnectionReset, want: "result=fault", level: "level=INFO"},
+		{name: "upstream error", err: errors.New("connection refused"), want: `error="connection refused"`, level: "level=ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			rt.logger = NewLogger(logFormatText, slog.LevelInfo, &buf)
+
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: http.StatusOK}
+			}
+
+			tt.ex.mode = rt.mode
+			rt.logExchange(r, resp, tt.err, &tt.ex, time.Second)
+
+			if !strings.Contains(buf.String(), tt.want) || !strings.Contains(buf.String(), tt.level) {
+				t.Errorf("log entry doesn't contain %s and %s: %s", tt.want, tt.level, buf.String())
+			}
+		})
+	}
+}
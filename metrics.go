@@ -0,0 +1,240 @@
+package gmeter
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//modes of the RoundTripper, the mode is empty until one of the
+//record, play or passthrough endpoints is called
+const (
+	modeRecord      = "record"
+	modePlay        = "play"
+	modePassthrough = "passthrough"
+)
+
+//latencyBuckets are the upper bounds (in seconds) of the upstream latency histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type (
+	//metrics collects the counters of the requests served by the RoundTripper,
+	//the zero value is ready to use
+	metrics struct {
+		lock sync.Mutex
+
+		requests          map[requestLabels]uint64
+		hits              map[string]uint64
+		misses            map[string]uint64
+		recordingFailures map[string]uint64
+
+		//upstream holds the latency histograms of the requests to the target by mode
+		upstream map[string]*histogram
+	}
+
+	requestLabels struct {
+		mode, cassette, method, status string
+	}
+
+	histogram struct {
+		//counts holds the number of observations in every bucket, not cumulative
+		counts []uint64
+		count  uint64
+		sum    float64
+	}
+
+	//gauges are the values of the current session that are collected on scrape
+	gauges struct {
+		mode     string
+		cassette string
+
+		//tracks is the number of tracks on the cassette, -1 if there is no cassette
+		tracks int
+	}
+)
+
+func (m *metrics) countRequest(mode, cassette, method, status string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.requests == nil {
+		m.requests = map[requestLabels]uint64{}
+	}
+
+	m.requests[requestLabels{mode: mode, cassette: cassette, method: method, status: status}]++
+}
+
+func (m *metrics) countHit(cassette string) {
+	m.increment(&m.hits, cassette)
+}
+
+func (m *metrics) countMiss(cassette string) {
+	m.increment(&m.misses, cassette)
+}
+
+func (m *metrics) countRecordingFailure(cassette string) {
+	m.increment(&m.recordingFailures, cassette)
+}
+
+func (m *metrics) increment(counter *map[string]uint64, label string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if *counter == nil {
+		*counter = map[string]uint64{}
+	}
+
+	(*counter)[label]++
+}
+
+func (m *metrics) observeUpstream(mode string, d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.upstream == nil {
+		m.upstream = map[string]*histogram{}
+	}
+
+	h, ok := m.upstream[mode]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.upstream[mode] = h
+	}
+
+	h.observe(d.Seconds())
+}
+
+func (h *histogram) observe(v float64) {
+	h.count++
+	h.sum += v
+
+	if i := sort.SearchFloat64s(latencyBuckets, v); i < len(latencyBuckets) {
+		h.counts[i]++
+	}
+}
+
+//write writes the metrics in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer, g gauges) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	header(w, "gmeter_requests_total", "counter", "Requests served by gmeter.")
+	keys := make([]requestLabels, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		return strings.Join([]string{a.mode, a.cassette, a.method, a.status}, "\x00") <
+			strings.Join([]string{b.mode, b.cassette, b.method, b.status}, "\x00")
+	})
+
+	for _, k := range keys {
+		fmt.Fprintf(w, "gmeter_requests_total{mode=%s,cassette=%s,method=%s,status=%s} %d\n",
+			labelValue(k.mode), labelValue(k.cassette), labelValue(k.method), labelValue(k.status), m.requests[k])
+	}
+
+	writeCounter(w, "gmeter_track_hits_total", "Requests played back from a track.", m.hits)
+	writeCounter(w, "gmeter_track_misses_total", "Requests that were not found on the cassette in play mode.", m.misses)
+	writeCounter(w, "gmeter_recording_failures_total", "Tracks that could not be written to the cassette.", m.recordingFailures)
+
+	header(w, "gmeter_upstream_latency_seconds", "histogram", "Time until the response headers are received from the target.")
+	modes := make([]string, 0, len(m.upstream))
+	for mode := range m.upstream {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+
+	for _, mode := range modes {
+		h := m.upstream[mode]
+
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "gmeter_upstream_latency_seconds_bucket{mode=%s,le=\"%s\"} %d\n",
+				labelValue(mode), strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+
+		fmt.Fprintf(w, "gmeter_upstream_latency_seconds_bucket{mode=%s,le=\"+Inf\"} %d\n", labelValue(mode), h.count)
+		fmt.Fprintf(w, "gmeter_upstream_latency_seconds_sum{mode=%s} %s\n", labelValue(mode), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "gmeter_upstream_latency_seconds_count{mode=%s} %d\n", labelValue(mode), h.count)
+	}
+
+	header(w, "gmeter_active_sessions", "gauge", "Active sessions by mode.")
+	for _, mode := range []string{modePassthrough, modePlay, modeRecord} {
+		active := 0
+		if g.mode == mode {
+			active = 1
+		}
+		fmt.Fprintf(w, "gmeter_active_sessions{mode=%s} %d\n", labelValue(mode), active)
+	}
+
+	header(w, "gmeter_loaded_tracks", "gauge", "Tracks on the cassette of the active session.")
+	if g.tracks >= 0 {
+		fmt.Fprintf(w, "gmeter_loaded_tracks{cassette=%s} %d\n", labelValue(g.cassette), g.tracks)
+	}
+}
+
+func header(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeCounter(w io.Writer, name, help string, counter map[string]uint64) {
+	header(w, name, "counter", help)
+	for _, cassette := range sortedKeys(counter) {
+		fmt.Fprintf(w, "%s{cassette=%s} %d\n", name, labelValue(cassette), counter[cassette])
+	}
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+//labelValue returns the quoted and escaped label value
+func labelValue(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+//Metrics writes the metrics in the Prometheus text format
+func (rt *RoundTripper) Metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	rt.lock.RLock()
+	g := gauges{mode: rt.mode, cassette: rt.cassette, tracks: -1}
+	if rt.vcr != nil {
+		stats := rt.vcr.Stats()
+		g.tracks = stats.TracksLoaded + stats.TracksRecorded
+	}
+	rt.lock.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	rt.metrics.write(w, g)
+}
+
+//instrumentUpstream measures the latency of the requests to the target in the mode
+func (rt *RoundTripper) instrumentUpstream(transport http.RoundTripper, mode string) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		start := time.Now()
+		resp, err := transport.RoundTrip(r)
+		rt.metrics.observeUpstream(mode, time.Since(start))
+
+		return resp, err
+	})
+}
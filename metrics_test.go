@@ -0,0 +1,82 @@
+package gmeter
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_metrics_write(t *testing.T) {
+	var m metrics
+	m.countRequest(modePlay, "users", "GET", "200")
+	m.countRequest(modePlay, "users", "GET", "200")
+	m.countRequest(modePlay, `a"b`, "POST", "error")
+	m.countHit("users")
+	m.countMiss("users")
+	m.countRecordingFailure("orders")
+	m.observeUpstream(modeRecord, 30*time.Millisecond)
+	m.observeUpstream(modeRecord, time.Minute)
+
+	var buf bytes.Buffer
+	m.write(&buf, gauges{mode: modePlay, cassette: "users", tracks: 5})
+
+	for _, want := range []string{
+		"# TYPE gmeter_requests_total counter\n",
+		`gmeter_requests_total{mode="play",cassette="a\"b",method="POST",status="error"} 1` + "\n" +
+			`gmeter_requests_total{mode="play",cassette="users",method="GET",status="200"} 2` + "\n",
+		`gmeter_track_hits_total{cassette="users"} 1` + "\n",
+		`gmeter_track_misses_total{cassette="users"} 1` + "\n",
+		`gmeter_recording_failures_total{cassette="orders"} 1` + "\n",
+		`gmeter_upstream_latency_seconds_bucket{mode="record",le="0.025"} 0` + "\n" +
+			`gmeter_upstream_latency_seconds_bucket{mode="record",le="0.05"} 1` + "\n",
+		`gmeter_upstream_latency_seconds_bucket{mode="record",le="10"} 1` + "\n" +
+			`gmeter_upstream_latency_seconds_bucket{mode="record",le="+Inf"} 2` + "\n" +
+			`gmeter_upstream_latency_seconds_sum{mode="record"} 60.03` + "\n" +
+			`gmeter_upstream_latency_seconds_count{mode="record"} 2` + "\n",
+		`gmeter_active_sessions{mode="passthrough"} 0` + "\n" +
+			`gmeter_active_sessions{mode="play"} 1` + "\n" +
+			`gmeter_active_sessions{mode="record"} 0` + "\n",
+		`gmeter_loaded_tracks{cassette="users"} 5` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics don't contain:\n%s\ngot:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRoundTripper_Metrics(t *testing.T) {
+	dir := writeCassette(t, "users", cassetteTrack("GET", "/users", "[]", "", "", ""))
+
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: Options{CassettePath: dir}}
+	rt.Play(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmeter/play", strings.NewReader(`{"cassette": "users"}`)))
+
+	for _, path := range []string{"/users", "/users"} {
+		rt.RoundTrip(httptest.NewRequest("GET", "http://example.com"+path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	rt.Metrics(w, httptest.NewRequest("GET", "/gmeter/metrics", nil))
+
+	for _, want := range []string{
+		`gmeter_requests_total{mode="play",cassette="users",method="GET",status="200"} 1`,
+		`gmeter_requests_total{mode="play",cassette="users",method="GET",status="error"} 1`,
+		`gmeter_track_hits_total{cassette="users"} 1`,
+		`gmeter_track_misses_total{cassette="users"} 1`,
+		`gmeter_active_sessions{mode="play"} 1`,
+		`gmeter_loaded_tracks{cassette="users"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics don't contain: %s\ngot:\n%s", want, w.Body.String())
+		}
+	}
+
+	w = httptest.NewRecorder()
+	rt.Metrics(w, httptest.NewRequest("POST", "/gmeter/metrics", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+}
@@ -0,0 +1,146 @@
+package gmeter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+type (
+	//miss describes what happens to the requests that are not found on the
+	//cassette in Play mode: they either get an error response with the given
+	//Status and ContentType or fall through to the Stub or to the Upstream
+	miss struct {
+		//Status is 502 if not set
+		Status int `json:"status,omitempty"`
+
+		//ContentType selects the format of the mismatch details: application/json
+		//or text (the default), the value is sent in the Content-Type header as is
+		ContentType string `json:"contentType,omitempty"`
+
+		//Stub is served instead of the error response
+		Stub *stubResponse `json:"stub,omitempty"`
+
+		//Upstream passes the requests to the target without recording them
+		Upstream bool `json:"upstream,omitempty"`
+	}
+
+	//missDetails is the JSON body of the miss response
+	missDetails struct {
+		Error   string `json:"error"`
+		Method  string `json:"method"`
+		URL     string `json:"url"`
+		Request string `json:"request"`
+
+		//Position and Expected describe the expected track in the sequential mode
+		Position *int   `json:"position,omitempty"`
+		Expected string `json:"expected,omitempty"`
+
+		//Closest lists the tracks that are closest to the request
+		Closest []govcr.TrackDiff `json:"closest,omitempty"`
+	}
+)
+
+func (m *miss) validate() error {
+	if m.Status != 0 && (m.Status < 100 || m.Status > 999) {
+		return fmt.Errorf("invalid miss status code: %d", m.Status)
+	}
+
+	if m.Stub != nil && m.Upstream {
+		return errors.New("miss can fall through either to the stub or to the upstream")
+	}
+
+	if m.Stub != nil {
+		s := stub{Response: *m.Stub}
+		if err := s.validate(); err != nil {
+			return fmt.Errorf("invalid miss stub: %v", err)
+		}
+	}
+
+	return nil
+}
+
+//transport returns the transport that is used by govcr when the request is not
+//found on the cassette, by default the request fails with trackNotFoundError
+func (m *miss) transport(options Options, logger *slog.Logger) (http.RoundTripper, error) {
+	switch {
+	case m == nil:
+		return nopTripper{}, nil
+	case m.Upstream:
+		return newTransport(options)
+	case m.Stub != nil:
+		s := &stub{Response: *m.Stub}
+		if err := s.validate(); err != nil {
+			return nil, err
+		}
+
+		return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			return s.roundTrip(r, logger)
+		}), nil
+	}
+
+	return nopTripper{}, nil
+}
+
+//write writes the miss response with the details of the error
+func (m *miss) write(w http.ResponseWriter, r *http.Request, err error) {
+	status, contentType := http.StatusBadGateway, "text/plain; charset=utf-8"
+	if m != nil && m.Status != 0 {
+		status = m.Status
+	}
+
+	if m != nil && m.ContentType != "" {
+		contentType = m.ContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	if !isJSON(contentType) {
+		io.WriteString(w, err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(newMissDetails(r, err))
+}
+
+func newMissDetails(r *http.Request, err error) missDetails {
+	details := missDetails{Method: r.Method, URL: r.URL.String()}
+
+	var (
+		seqErr   *govcr.SequenceError
+		notFound *trackNotFoundError
+	)
+
+	switch {
+	case errors.As(err, &seqErr):
+		details.Error = "out of sequence request"
+		details.Request = seqErr.Actual
+		details.Position = &seqErr.Position
+		details.Expected = seqErr.Expected
+		if seqErr.Expected == "" {
+			details.Error = "unexpected request, all tracks have been played"
+		}
+	case errors.As(err, &notFound):
+		details.Error = "track not found"
+		details.Request = notFound.dump
+		details.Closest = notFound.closest
+	default:
+		details.Error = err.Error()
+	}
+
+	return details
+}
+
+//isJSON checks whether the content type is application/json or application/*+json
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
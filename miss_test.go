@@ -0,0 +1,126 @@
+package gmeter
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_miss_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		miss    miss
+		wantErr bool
+	}{
+		{name: "bad status", miss: miss{Status: 42}, wantErr: true},
+		{name: "stub and upstream", miss: miss{Stub: &stubResponse{}, Upstream: true}, wantErr: true},
+		{name: "bad stub", miss: miss{Stub: &stubResponse{Status: 42}}, wantErr: true},
+		{name: "success", miss: miss{Status: http.StatusNotFound, ContentType: "application/json"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.miss.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("miss.validate error = %v, wantErr: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_Play_miss(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "live")
+	}))
+	defer upstream.Close()
+
+	dir := writeCassette(t, "miss", cassetteTrack("GET", "/users", "[]", "", "", ""))
+
+	tests := []struct {
+		name    string
+		play    string
+		inspect func(w *httptest.ResponseRecorder, t *testing.T)
+	}{
+		{
+			name: "default",
+			play: `{"cassette": "miss"}`,
+			inspect: func(w *httptest.ResponseRecorder, t *testing.T) {
+				if w.Code != http.StatusBadGateway || w.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+					t.Errorf("unexpected response: %d %s", w.Code, w.Header().Get("Content-Type"))
+				}
+
+				if !strings.HasPrefix(w.Body.String(), "track not found for request") {
+					t.Errorf("unexpected body: %s", w.Body.String())
+				}
+			},
+		},
+		{
+			name: "json",
+			play: `{"cassette": "miss", "miss": {"status": 599, "contentType": "application/problem+json"}}`,
+			inspect: func(w *httptest.ResponseRecorder, t *testing.T) {
+				if w.Code != 599 || w.Header().Get("Content-Type") != "application/problem+json" {
+					t.Errorf("unexpected response: %d %s", w.Code, w.Header().Get("Content-Type"))
+				}
+
+				var details missDetails
+				if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+					t.Fatalf("failed to decode details: %v", err)
+				}
+
+				if details.Error != "track not found" || details.Method != "GET" || details.URL != upstream.URL+"/orders" {
+					t.Errorf("unexpected details: %+v", details)
+				}
+
+				if len(details.Closest) != 1 || details.Closest[0].Diffs[0].Field != "host" {
+					t.Errorf("unexpected closest tracks: %+v", details.Closest)
+				}
+			},
+		},
+		{
+			name: "stub",
+			play: `{"cassette": "miss", "miss": {"stub": {"status": 404, "json": {"error": "not found"}}}}`,
+			inspect: func(w *httptest.ResponseRecorder, t *testing.T) {
+				if w.Code != http.StatusNotFound || w.Body.String() != `{"error": "not found"}` {
+					t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+				}
+			},
+		},
+		{
+			name: "upstream",
+			play: `{"cassette": "miss", "miss": {"upstream": true}}`,
+			inspect: func(w *httptest.ResponseRecorder, t *testing.T) {
+				if w.Code != http.StatusOK || w.Body.String() != "live" {
+					t.Errorf("unexpected response: %d %s", w.Code, w.Body.String())
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: Options{CassettePath: dir}}
+
+			w := httptest.NewRecorder()
+			rt.Play(w, httptest.NewRequest("POST", "/gmeter/play", strings.NewReader(tt.play)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("play failed: %d", w.Code)
+			}
+
+			r := httptest.NewRequest("GET", upstream.URL+"/orders", nil)
+
+			w = httptest.NewRecorder()
+			if resp, err := rt.RoundTrip(r); err != nil {
+				rt.ErrorHandler(w, r, err)
+			} else {
+				w.WriteHeader(resp.StatusCode)
+				io.Copy(w, resp.Body)
+			}
+
+			tt.inspect(w, t)
+		})
+	}
+}
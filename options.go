@@ -1,10 +1,15 @@
 package gmeter
 
 import (
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 //Options contains parsed command line options
@@ -13,6 +18,65 @@ type Options struct {
 	ListenAddress string
 	TargetURL     *url.URL
 	Insecure      bool
+
+	//TLSCertFile and TLSKeyFile are used to serve HTTPS, if TLS is set
+	//and both are empty a self-signed certificate is generated on start
+	TLS         bool
+	TLSCertFile string
+	TLSKeyFile  string
+
+	//ClientCertFile and ClientKeyFile are used to authenticate gmeter at the
+	//target when recording, RootCAFiles are added to the system CA pool
+	ClientCertFile string
+	ClientKeyFile  string
+	RootCAFiles    []string
+	TLSMinVersion  uint16
+	ServerName     string
+
+	//UpstreamProxy is used to reach the target when recording,
+	//if it's nil HTTP_PROXY, HTTPS_PROXY and NO_PROXY env variables are used
+	UpstreamProxy *url.URL
+
+	//H2C makes gmeter talk HTTP/2 over cleartext (prior knowledge) to
+	//the http:// target, e.g. to record plaintext gRPC services
+	H2C bool
+
+	//StubsDir is a directory with the stub definitions (*.json files)
+	StubsDir string
+
+	//LogFormat is either text (logfmt) or json, LogLevel is the minimum level of the log entries
+	LogFormat string
+	LogLevel  slog.Level
+
+	//ConfigFile is the JSON config file with the values of the flags
+	ConfigFile string
+
+	//Mode is the mode (record, play or passthrough) gmeter starts in with the Cassette,
+	//if it's empty gmeter waits for a call to /gmeter/record, /gmeter/play or /gmeter/passthrough
+	Mode     string
+	Cassette string
+
+	//ShutdownTimeout is how long the in-flight exchanges are waited for on shutdown
+	ShutdownTimeout time.Duration
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//stringsFlag is a flag.Value that collects values of the repeated flag
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *stringsFlag) Set(value string) error {
+	*sf = append(*sf, value)
+	return nil
 }
 
 type exitFunc func(int)
@@ -20,6 +84,28 @@ type exitFunc func(int)
 //GetOptions parses arguments and returns Options struct on success, otherwise
 //writes error message to the stderr writer and calls exit function
 func GetOptions(arguments []string, stdout, stderr io.Writer, exit exitFunc) Options {
+	flagset, options, help, errors := parseOptions(arguments)
+
+	if help {
+		flagset.SetOutput(stdout)
+		flagset.Usage()
+		exit(0)
+	}
+
+	if len(errors) > 0 {
+		for _, e := range errors {
+			fmt.Fprintf(stderr, "%s\n", e)
+		}
+		flagset.Usage()
+		exit(2)
+	}
+
+	return options
+}
+
+//parseOptions parses the arguments, the config file and the env variables, the flags
+//set in the arguments override the env variables that override the config file
+func parseOptions(arguments []string) (*flag.FlagSet, Options, bool, []string) {
 	var (
 		flagset  = flag.NewFlagSet("gmeter", flag.ExitOnError)
 		listen   = flagset.String("l", "localhost:8080", "listen address")
@@ -27,17 +113,35 @@ func GetOptions(arguments []string, stdout, stderr io.Writer, exit exitFunc) Opt
 		dir      = flagset.String("d", ".", "cassettes dir")
 		help     = flagset.Bool("h", false, "display this help text and exit")
 		insecure = flagset.Bool("insecure", false, "skip HTTPs checks")
+		useTLS   = flagset.Bool("tls", false, "serve HTTPs using a self-signed certificate unless -tls-cert and -tls-key are set")
+		tlsCert  = flagset.String("tls-cert", "", "TLS certificate file to serve HTTPs")
+		tlsKey   = flagset.String("tls-key", "", "TLS private key file to serve HTTPs")
+
+		clientCert    = flagset.String("client-cert", "", "client TLS certificate file to present to the target")
+		clientKey     = flagset.String("client-key", "", "client TLS private key file")
+		tlsMinVersion = flagset.String("tls-min-version", "", "minimum TLS version of the target connection: 1.0, 1.1, 1.2 or 1.3")
+		serverName    = flagset.String("sni", "", "server name to send to the target instead of its host")
+		upstreamProxy = flagset.String("upstream-proxy", "", "proxy URL (http, https or socks5) to reach the target, overrides HTTP(S)_PROXY env variables")
+		h2c           = flagset.Bool("h2c", false, "use HTTP/2 over cleartext to reach the http:// target (e.g. plaintext gRPC)")
+		stubsDir      = flagset.String("stubs", "", "directory with the stub definitions (*.json files) to serve along with the cassettes")
+		logFormat     = flagset.String("log-format", logFormatText, "log format: text (logfmt) or json")
+		logLevel      = flagset.String("log-level", "info", "minimum log level: debug, info, warn or error")
+		configFile    = flagset.String("c", "", "JSON config file, it's reloaded on change")
+		mode          = flagset.String("mode", "", "start in the mode: record, play or passthrough")
+		cassette      = flagset.String("cassette", "", "cassette to record or play when started with -mode record or -mode play")
+		shutdown      = flagset.Duration("shutdown-timeout", 10*time.Second, "how long to wait for the in-flight requests on shutdown")
+		rootCAs       stringsFlag
 	)
 
+	flagset.Var(&rootCAs, "ca", "PEM file with additional CA certificates to trust, can be repeated")
+
 	flagset.Parse(arguments)
 
 	if *help {
-		flagset.SetOutput(stdout)
-		flagset.Usage()
-		exit(0)
+		return flagset, Options{}, true, nil
 	}
 
-	var errors []string
+	errors := applyConfig(flagset, *configFile, os.LookupEnv)
 
 	if *target == "" {
 		errors = append(errors, "missing target base URL: -t")
@@ -52,18 +156,91 @@ func GetOptions(arguments []string, stdout, stderr io.Writer, exit exitFunc) Opt
 		errors = append(errors, fmt.Sprintf("unsupported scheme: %q", targetURL.Scheme))
 	}
 
-	if len(errors) > 0 {
-		for _, e := range errors {
-			fmt.Fprintf(stderr, "%s\n", e)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		errors = append(errors, "both -tls-cert and -tls-key should be set")
+	}
+
+	if (*clientCert == "") != (*clientKey == "") {
+		errors = append(errors, "both -client-cert and -client-key should be set")
+	}
+
+	minVersion, ok := tlsVersions[*tlsMinVersion]
+	if !ok && *tlsMinVersion != "" {
+		errors = append(errors, fmt.Sprintf("unsupported TLS version: %q", *tlsMinVersion))
+	}
+
+	proxyURL, err := parseProxyURL(*upstreamProxy)
+	if err != nil {
+		errors = append(errors, err.Error())
+	}
+
+	if *logFormat != logFormatText && *logFormat != logFormatJSON {
+		errors = append(errors, fmt.Sprintf("unsupported log format: %q", *logFormat))
+	}
+
+	level, ok := logLevels[*logLevel]
+	if !ok {
+		errors = append(errors, fmt.Sprintf("unsupported log level: %q", *logLevel))
+	}
+
+	switch *mode {
+	case "", modePassthrough:
+		if *cassette != "" {
+			errors = append(errors, "-cassette requires -mode record or -mode play")
 		}
-		flagset.Usage()
-		exit(2)
+	case modeRecord, modePlay:
+		if *cassette == "" {
+			errors = append(errors, fmt.Sprintf("missing cassette name for -mode %s: -cassette", *mode))
+		}
+	default:
+		errors = append(errors, fmt.Sprintf("unsupported mode: %q", *mode))
 	}
 
-	return Options{
+	if len(errors) > 0 {
+		return flagset, Options{}, false, errors
+	}
+
+	return flagset, Options{
 		CassettePath:  *dir,
 		Insecure:      *insecure,
 		ListenAddress: *listen,
 		TargetURL:     targetURL,
+		TLS:           *useTLS || *tlsCert != "",
+		TLSCertFile:   *tlsCert,
+		TLSKeyFile:    *tlsKey,
+
+		ClientCertFile: *clientCert,
+		ClientKeyFile:  *clientKey,
+		RootCAFiles:    rootCAs,
+		TLSMinVersion:  minVersion,
+		ServerName:     *serverName,
+		UpstreamProxy:  proxyURL,
+		H2C:            *h2c,
+		StubsDir:       *stubsDir,
+		LogFormat:      *logFormat,
+		LogLevel:       level,
+		ConfigFile:     *configFile,
+		Mode:           *mode,
+		Cassette:       *cassette,
+
+		ShutdownTimeout: *shutdown,
+	}, false, nil
+}
+
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse upstream proxy URL: %v", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+		return proxyURL, nil
 	}
+
+	return nil, fmt.Errorf("unsupported upstream proxy scheme: %q", proxyURL.Scheme)
 }
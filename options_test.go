@@ -1,11 +1,14 @@
 package gmeter
 
 import (
+	"crypto/tls"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/url"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestGetOptions(t *testing.T) {
@@ -65,6 +68,206 @@ func TestGetOptions(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "tls key without certificate",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-tls-key", "gmeter.key"},
+					stderr:    ioutil.Discard,
+					exit: func(code int) {
+						if code != 2 {
+							t.Errorf("unexpected exit code, got: %d, want: 2", code)
+						}
+						t.Skip()
+					},
+				}
+			},
+		},
+		{
+			name: "bad TLS version",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-tls-min-version", "2.0"},
+					stderr:    ioutil.Discard,
+					exit: func(code int) {
+						if code != 2 {
+							t.Errorf("unexpected exit code, got: %d, want: 2", code)
+						}
+						t.Skip()
+					},
+				}
+			},
+		},
+		{
+			name: "bad log level",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-log-level", "trace"},
+					stderr:    ioutil.Discard,
+					exit: func(code int) {
+						if code != 2 {
+							t.Errorf("unexpected exit code, got: %d, want: 2", code)
+						}
+						t.Skip()
+					},
+				}
+			},
+		},
+		{
+			name: "json log",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-log-format", "json", "-log-level", "debug"},
+				}
+			},
+			want1: Options{
+				CassettePath:  ".",
+				ListenAddress: "localhost:8080",
+				TargetURL:     &url.URL{Scheme: "http", Host: "github.com"},
+				LogFormat:     "json",
+				LogLevel:      slog.LevelDebug,
+
+				ShutdownTimeout: 10 * time.Second,
+			},
+		},
+		{
+			name: "upstream TLS",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "https://github.com", "-client-cert", "client.crt", "-client-key", "client.key",
+						"-ca", "ca1.pem", "-ca", "ca2.pem", "-tls-min-version", "1.2", "-sni", "api.github.com"},
+				}
+			},
+			want1: Options{
+				CassettePath:   ".",
+				ListenAddress:  "localhost:8080",
+				TargetURL:      &url.URL{Scheme: "https", Host: "github.com"},
+				LogFormat:      "text",
+				ClientCertFile: "client.crt",
+				ClientKeyFile:  "client.key",
+				RootCAFiles:    []string{"ca1.pem", "ca2.pem"},
+				TLSMinVersion:  tls.VersionTLS12,
+				ServerName:     "api.github.com",
+
+				ShutdownTimeout: 10 * time.Second,
+			},
+		},
+		{
+			name: "unsupported proxy scheme",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-upstream-proxy", "ftp://proxy.local"},
+					stderr:    ioutil.Discard,
+					exit: func(code int) {
+						if code != 2 {
+							t.Errorf("unexpected exit code, got: %d, want: 2", code)
+						}
+						t.Skip()
+					},
+				}
+			},
+		},
+		{
+			name: "socks5 proxy",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-upstream-proxy", "socks5://proxy.local:1080"},
+				}
+			},
+			want1: Options{
+				CassettePath:  ".",
+				ListenAddress: "localhost:8080",
+				TargetURL:     &url.URL{Scheme: "http", Host: "github.com"},
+				LogFormat:     "text",
+				UpstreamProxy: &url.URL{Scheme: "socks5", Host: "proxy.local:1080"},
+
+				ShutdownTimeout: 10 * time.Second,
+			},
+		},
+		{
+			name: "h2c",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://localhost:50051", "-h2c"},
+				}
+			},
+			want1: Options{
+				CassettePath:  ".",
+				ListenAddress: "localhost:8080",
+				TargetURL:     &url.URL{Scheme: "http", Host: "localhost:50051"},
+				LogFormat:     "text",
+				H2C:           true,
+
+				ShutdownTimeout: 10 * time.Second,
+			},
+		},
+		{
+			name: "tls",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-tls-cert", "gmeter.crt", "-tls-key", "gmeter.key"},
+				}
+			},
+			want1: Options{
+				CassettePath:  ".",
+				ListenAddress: "localhost:8080",
+				TargetURL:     &url.URL{Scheme: "http", Host: "github.com"},
+				LogFormat:     "text",
+				TLS:           true,
+				TLSCertFile:   "gmeter.crt",
+				TLSKeyFile:    "gmeter.key",
+
+				ShutdownTimeout: 10 * time.Second,
+			},
+		},
+		{
+			name: "play mode without cassette",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-mode", "play"},
+					stderr:    ioutil.Discard,
+					exit: func(code int) {
+						if code != 2 {
+							t.Errorf("unexpected exit code, got: %d, want: 2", code)
+						}
+						t.Skip()
+					},
+				}
+			},
+		},
+		{
+			name: "bad mode",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-mode", "replay", "-cassette", "users"},
+					stderr:    ioutil.Discard,
+					exit: func(code int) {
+						if code != 2 {
+							t.Errorf("unexpected exit code, got: %d, want: 2", code)
+						}
+						t.Skip()
+					},
+				}
+			},
+		},
+		{
+			name: "play mode",
+			args: func(t *testing.T) args {
+				return args{
+					arguments: []string{"-t", "http://github.com", "-mode", "play", "-cassette", "users"},
+				}
+			},
+			want1: Options{
+				CassettePath:  ".",
+				ListenAddress: "localhost:8080",
+				TargetURL:     &url.URL{Scheme: "http", Host: "github.com"},
+				LogFormat:     "text",
+				Mode:          "play",
+				Cassette:      "users",
+
+				ShutdownTimeout: 10 * time.Second,
+			},
+		},
 		{
 			name: "success",
 			args: func(t *testing.T) args {
@@ -77,6 +280,9 @@ func TestGetOptions(t *testing.T) {
 				Insecure:      false,
 				ListenAddress: "localhost:8080",
 				TargetURL:     &url.URL{Scheme: "http", Host: "github.com"},
+				LogFormat:     "text",
+
+				ShutdownTimeout: 10 * time.Second,
 			},
 		},
 	}
@@ -0,0 +1,36 @@
+package gmeter
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+//Scenarios shows (GET) or resets (DELETE) the current states of the scenarios
+//on the cassette, the scenarios to reset can be limited with the name parameter
+func (rt *RoundTripper) Scenarios(w http.ResponseWriter, r *http.Request) {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+
+	if rt.vcr == nil {
+		rt.logger.Warn("scenarios are available only in record and play modes")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rt.vcr.ScenarioStates())
+	case http.MethodDelete:
+		names := r.URL.Query()["name"]
+		rt.vcr.ResetScenarios(names...)
+
+		if len(names) == 0 {
+			rt.logger.Info("reset all scenarios", "session", rt.session)
+		} else {
+			rt.logger.Info("reset scenarios", "session", rt.session, "names", names)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
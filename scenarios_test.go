@@ -0,0 +1,99 @@
+package gmeter
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func cassetteTrack(method, path, body, scenario, requiredState, newState string) string {
+	return fmt.Sprintf(`{
+		"Request": {"Method": %q, "URL": {"Scheme": "http", "Host": "example.com", "Path": %q}, "Header": {}},
+		"Response": {"Status": "200 OK", "StatusCode": 200, "Proto": "HTTP/1.1", "ProtoMajor": 1, "ProtoMinor": 1,
+			"Header": {}, "Body": %q, "ContentLength": %d},
+		"Scenario": %q, "RequiredState": %q, "NewState": %q
+	}`, method, path, base64.StdEncoding.EncodeToString([]byte(body)), len(body), scenario, requiredState, newState)
+}
+
+//writeCassette writes the cassette with the tracks to a temporary dir and returns the dir
+func writeCassette(t *testing.T, name string, tracks ...string) string {
+	dir := t.TempDir()
+	cassette := fmt.Sprintf(`{"Name": %q, "Tracks": [%s]}`, name, strings.Join(tracks, ","))
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".cassette"), []byte(cassette), 0600); err != nil {
+		t.Fatalf("failed to write cassette: %v", err)
+	}
+
+	return dir
+}
+
+func TestRoundTripper_Scenarios(t *testing.T) {
+	dir := writeCassette(t, "orders",
+		cassetteTrack("GET", "/order/1", "pending", "order", "Started", ""),
+		cassetteTrack("POST", "/order/1/pay", "ok", "order", "Started", "paid"),
+		cassetteTrack("GET", "/order/1", "paid", "order", "paid", ""),
+	)
+
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: Options{CassettePath: dir}}
+
+	scenarios := func(method, query string) string {
+		w := httptest.NewRecorder()
+		rt.Scenarios(w, httptest.NewRequest(method, "/gmeter/scenarios"+query, nil))
+		return fmt.Sprintf("%d %s", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+
+	if got := scenarios("GET", ""); got != "409 " {
+		t.Errorf("scenarios without a cassette got: %s", got)
+	}
+
+	rt.Play(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmeter/play", strings.NewReader(`{"cassette": "orders"}`)))
+
+	steps := []struct {
+		method, path string
+		want         string
+		wantStates   string
+	}{
+		{method: "GET", path: "/order/1", want: "pending", wantStates: `200 {"order":"Started"}`},
+		{method: "GET", path: "/order/1", want: "pending", wantStates: `200 {"order":"Started"}`},
+		{method: "POST", path: "/order/1/pay", want: "ok", wantStates: `200 {"order":"paid"}`},
+		{method: "GET", path: "/order/1", want: "paid", wantStates: `200 {"order":"paid"}`},
+		{method: "GET", path: "/order/1", want: "paid", wantStates: `200 {"order":"paid"}`},
+	}
+
+	for i, step := range steps {
+		resp, err := rt.RoundTrip(httptest.NewRequest(step.method, "http://example.com"+step.path, nil))
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != step.want {
+			t.Errorf("step %d: got: %s, want: %s", i, body, step.want)
+		}
+
+		if got := scenarios("GET", ""); got != step.wantStates {
+			t.Errorf("step %d: scenarios got: %s, want: %s", i, got, step.wantStates)
+		}
+	}
+
+	if got := scenarios("DELETE", "?name=order"); got != "200 " {
+		t.Errorf("reset got: %s", got)
+	}
+
+	if got := scenarios("GET", ""); got != `200 {"order":"Started"}` {
+		t.Errorf("scenarios after reset got: %s", got)
+	}
+
+	if got := scenarios("PUT", ""); got != "405 " {
+		t.Errorf("bad method got: %s", got)
+	}
+
+	if resp, err := rt.RoundTrip(httptest.NewRequest("GET", "http://example.com/order/1", nil)); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected result after reset: %v, %v", resp, err)
+	}
+}
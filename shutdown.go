@@ -0,0 +1,79 @@
+package gmeter
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+//summary counts the exchanges served since the start by their results,
+//the zero value is ready to use
+type summary struct {
+	lock     sync.Mutex
+	requests int
+	results  map[string]int
+}
+
+//summaryResults are the results in the order they appear in the summary, the
+//stub, fault and passthrough results are added only if there were such exchanges
+var summaryResults = []string{govcr.ResultRecorded, govcr.ResultPlayed, govcr.ResultMissed, resultStub, resultFault, resultPassthrough}
+
+func (s *summary) count(result string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.results == nil {
+		s.results = map[string]int{}
+	}
+
+	s.requests++
+	if result != "" {
+		s.results[result]++
+	}
+}
+
+func (s *summary) attrs() []slog.Attr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	attrs := []slog.Attr{slog.Int("requests", s.requests)}
+	for i, result := range summaryResults {
+		if n := s.results[result]; n > 0 || i < len(summaryResults)-3 {
+			attrs = append(attrs, slog.Int(result, n))
+		}
+	}
+
+	return attrs
+}
+
+//Drain ends the long polling and streaming requests of the exchanges so that they
+//don't hold the server on shutdown, it's meant to be registered with
+//http.Server.RegisterOnShutdown
+func (rt *RoundTripper) Drain() {
+	rt.journal.close()
+}
+
+//Close writes the cassette of the current session to file and logs the summary
+//of the exchanges, it's meant to be called on shutdown after the in-flight
+//exchanges are done. The WebSocket connections that are being recorded are
+//closed since the server doesn't wait for them, their tracks are saved too
+func (rt *RoundTripper) Close() error {
+	rt.lock.RLock()
+	vcr, session, mode, cassette := rt.vcr, rt.session, rt.mode, rt.cassette
+	rt.lock.RUnlock()
+
+	var err error
+	if vcr != nil {
+		if err = vcr.Flush(); err != nil {
+			err = fmt.Errorf("failed to save cassette %q: %v", cassette, err)
+		}
+	}
+
+	attrs := append([]slog.Attr{slog.String("session", session), slog.String("mode", mode), slog.String("cassette", cassette)}, rt.summary.attrs()...)
+	rt.logger.LogAttrs(context.Background(), slog.LevelInfo, "session summary", attrs...)
+
+	return err
+}
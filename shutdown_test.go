@@ -0,0 +1,110 @@
+package gmeter
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_summary_attrs(t *testing.T) {
+	var s summary
+	for _, result := range []string{"played", "played", "missed", "stub", ""} {
+		s.count(result)
+	}
+
+	var got []string
+	for _, a := range s.attrs() {
+		got = append(got, a.String())
+	}
+
+	if want := "requests=5 recorded=0 played=2 missed=1 stub=1"; strings.Join(got, " ") != want {
+		t.Errorf("got: %q, want: %q", strings.Join(got, " "), want)
+	}
+}
+
+func TestRoundTripper_Close(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer target.Close()
+
+	dir := t.TempDir()
+
+	var buf bytes.Buffer
+	rt := NewRoundTripper(Options{CassettePath: dir, Mode: modeRecord, Cassette: "users"}, NewLogger(logFormatJSON, slog.LevelInfo, &buf))
+	if err := rt.Start(); err != nil {
+		t.Fatalf("failed to start: %v", err)
+	}
+
+	resp, err := rt.RoundTrip(httptest.NewRequest("GET", target.URL+"/users", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if err := rt.Close(); err != nil {
+		t.Fatalf("failed to close: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || filepath.Base(files[0]) != "users.cassette" {
+		t.Errorf("unexpected files in the cassettes dir: %v", files)
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c struct{ Tracks []json.RawMessage }
+	if err := json.Unmarshal(data, &c); err != nil || len(c.Tracks) != 1 {
+		t.Errorf("unexpected cassette: %v %s", err, data)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	var entry map[string]interface{}
+	json.Unmarshal([]byte(lines[len(lines)-1]), &entry)
+
+	if entry["msg"] != "session summary" || entry["cassette"] != "users" || entry["recorded"] != 1.0 || entry["played"] != 0.0 {
+		t.Errorf("unexpected summary: %v", entry)
+	}
+}
+
+func TestRoundTripper_Drain(t *testing.T) {
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler)}
+
+	done := make(chan struct{})
+	go func() {
+		rt.Exchanges(httptest.NewRecorder(), httptest.NewRequest("GET", "/gmeter/exchanges?wait=1m", nil))
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	rt.Drain()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("long polling request is not ended")
+	}
+
+	//the requests after the drain end right away
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/gmeter/exchanges", nil)
+	r.Header.Set("Accept", "text/event-stream")
+	rt.Exchanges(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+}
@@ -0,0 +1,287 @@
+package gmeter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	//stub is a hand-written response that is served to the requests that
+	//match Method, Path and the optional Headers and Body matchers, all of
+	//them except for Method are regular expressions
+	stub struct {
+		Method  string            `json:"method,omitempty"`
+		Path    string            `json:"path,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    string            `json:"body,omitempty"`
+
+		Response stubResponse `json:"response"`
+
+		path    *regexp.Regexp
+		headers map[string]*regexp.Regexp
+		body    *regexp.Regexp
+	}
+
+	stubResponse struct {
+		//Status is 200 if not set
+		Status  int               `json:"status,omitempty"`
+		Headers map[string]string `json:"headers,omitempty"`
+		Body    string            `json:"body,omitempty"`
+
+		//JSON is used instead of the Body to write JSON responses
+		//without escaping, Content-Type is set to application/json
+		JSON json.RawMessage `json:"json,omitempty"`
+
+		//Template enables rendering of the templates in the headers
+		//and the body of the response
+		Template bool `json:"template,omitempty"`
+
+		//Latency delays the response
+		Latency duration `json:"latency,omitempty"`
+	}
+)
+
+func (s *stub) validate() error {
+	if s.Response.Status != 0 && (s.Response.Status < 100 || s.Response.Status > 999) {
+		return fmt.Errorf("invalid status code: %d", s.Response.Status)
+	}
+
+	if s.Response.Body != "" && len(s.Response.JSON) > 0 {
+		return errors.New("stub response can't have both body and json")
+	}
+
+	if s.Response.Latency < 0 {
+		return errors.New("latency can't be negative")
+	}
+
+	path, err := regexp.Compile(s.Path)
+	if err != nil {
+		return fmt.Errorf("failed to compile path: %v", err)
+	}
+
+	body, err := regexp.Compile(s.Body)
+	if err != nil {
+		return fmt.Errorf("failed to compile body: %v", err)
+	}
+
+	headers := make(map[string]*regexp.Regexp, len(s.Headers))
+	for k, v := range s.Headers {
+		headers[k], err = regexp.Compile(v)
+		if err != nil {
+			return fmt.Errorf("failed to compile %s header: %v", k, err)
+		}
+	}
+
+	s.path, s.body, s.headers = path, body, headers
+	return nil
+}
+
+func (s *stub) matches(r *http.Request) bool {
+	if s.Method != "" && !strings.EqualFold(s.Method, r.Method) {
+		return false
+	}
+
+	if !s.path.MatchString(r.URL.Path) {
+		return false
+	}
+
+	for k, re := range s.headers {
+		if !re.MatchString(r.Header.Get(k)) {
+			return false
+		}
+	}
+
+	if s.Body == "" {
+		return true
+	}
+
+	body, err := readBody(r)
+	return err == nil && s.body.Match(body)
+}
+
+//roundTrip returns the stub response
+func (s *stub) roundTrip(r *http.Request, logger *slog.Logger) (*http.Response, error) {
+	if s.Response.Latency > 0 {
+		select {
+		case <-time.After(time.Duration(s.Response.Latency)):
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	}
+
+	status := s.Response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	header := http.Header{}
+	body := []byte(s.Response.Body)
+
+	if len(s.Response.JSON) > 0 {
+		header.Set("Content-Type", "application/json")
+		body = s.Response.JSON
+	}
+
+	for k, v := range s.Response.Headers {
+		header.Set(k, v)
+	}
+
+	if s.Response.Template {
+		h, b := renderTemplates(logger)(header, body, r)
+		header, body = *h, *b
+	}
+
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}, nil
+}
+
+//readBody reads the request body and restores it so it can be read again
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	return body, err
+}
+
+//decodeStubs decodes a single stub or an array of stubs and validates them
+func decodeStubs(data []byte) ([]stub, error) {
+	var stubs []stub
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &stubs); err != nil {
+			return nil, fmt.Errorf("failed to decode stubs: %v", err)
+		}
+	} else {
+		var s stub
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("failed to decode stub: %v", err)
+		}
+		stubs = append(stubs, s)
+	}
+
+	for i := range stubs {
+		if err := stubs[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid stub %s %s: %v", stubs[i].Method, stubs[i].Path, err)
+		}
+	}
+
+	return stubs, nil
+}
+
+//LoadStubs loads the stubs from *.json files in the dir, every file contains
+//a stub or an array of stubs. The files are loaded in the lexical order
+//and the stubs that are loaded first take precedence
+func (rt *RoundTripper) LoadStubs(dir string) error {
+	filenames, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	var stubs []stub
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		fileStubs, err := decodeStubs(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+
+		stubs = append(stubs, fileStubs...)
+	}
+
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	rt.stubs = append(rt.stubs, stubs...)
+	rt.logger.Info("loaded stubs", "count", len(stubs), "dir", dir)
+
+	return nil
+}
+
+//matchStub returns the first stub that matches the request
+func matchStub(stubs []stub, r *http.Request) *stub {
+	for i := range stubs {
+		if stubs[i].matches(r) {
+			return &stubs[i]
+		}
+	}
+
+	return nil
+}
+
+//Stubs lists (GET), adds (POST) or removes all (DELETE) stubs
+func (rt *RoundTripper) Stubs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rt.lock.RLock()
+		defer rt.lock.RUnlock()
+
+		stubs := rt.stubs
+		if stubs == nil {
+			stubs = []stub{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stubs)
+	case http.MethodPost:
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			rt.logger.Error("failed to read stubs", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		stubs, err := decodeStubs(data)
+		if err != nil {
+			rt.logger.Error("invalid stubs", "error", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+
+		rt.stubs = append(rt.stubs, stubs...)
+		for _, s := range stubs {
+			rt.logger.Info("added stub", "method", s.Method, "path", s.Path)
+		}
+	case http.MethodDelete:
+		rt.lock.Lock()
+		defer rt.lock.Unlock()
+
+		rt.stubs = nil
+		rt.logger.Info("removed all stubs")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
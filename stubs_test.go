@@ -0,0 +1,269 @@
+package gmeter
+
+import (
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_stub_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		stub    stub
+		wantErr bool
+	}{
+		{name: "bad status", stub: stub{Response: stubResponse{Status: 42}}, wantErr: true},
+		{name: "body and json", stub: stub{Response: stubResponse{Body: "a", JSON: []byte(`{}`)}}, wantErr: true},
+		{name: "negative latency", stub: stub{Response: stubResponse{Latency: -1}}, wantErr: true},
+		{name: "bad path", stub: stub{Path: "("}, wantErr: true},
+		{name: "bad body", stub: stub{Body: "("}, wantErr: true},
+		{name: "bad header", stub: stub{Headers: map[string]string{"X-Id": "("}}, wantErr: true},
+		{name: "success", stub: stub{Method: "GET", Path: "^/users", Response: stubResponse{Status: 404}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.stub.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("stub.validate error = %v, wantErr: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_stub_matches(t *testing.T) {
+	tests := []struct {
+		name    string
+		stub    stub
+		request func() *http.Request
+		want    bool
+	}{
+		{
+			name:    "method mismatch",
+			stub:    stub{Method: "POST"},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/users", nil) },
+		},
+		{
+			name:    "path mismatch",
+			stub:    stub{Path: "^/orders"},
+			request: func() *http.Request { return httptest.NewRequest("GET", "/users", nil) },
+		},
+		{
+			name: "header mismatch",
+			stub: stub{Headers: map[string]string{"Authorization": "^Bearer "}},
+			request: func() *http.Request {
+				return httptest.NewRequest("GET", "/users", nil)
+			},
+		},
+		{
+			name: "body mismatch",
+			stub: stub{Body: `"name":\s*"gmeter"`},
+			request: func() *http.Request {
+				return httptest.NewRequest("POST", "/users", strings.NewReader(`{"name": "other"}`))
+			},
+		},
+		{
+			name: "match",
+			stub: stub{Method: "post", Path: `^/users/\d+$`, Headers: map[string]string{"Authorization": "^Bearer "}, Body: `"name":\s*"gmeter"`},
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/users/1", strings.NewReader(`{"name": "gmeter"}`))
+				r.Header.Set("Authorization", "Bearer token")
+				return r
+			},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.stub.validate(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			r := tt.request()
+			if got := tt.stub.matches(r); got != tt.want {
+				t.Errorf("stub.matches got: %t, want: %t", got, tt.want)
+			}
+
+			if r.Body == nil {
+				return
+			}
+
+			//the body should be readable after matching
+			if body, _ := ioutil.ReadAll(r.Body); tt.stub.Body != "" && len(body) == 0 {
+				t.Errorf("request body is consumed by stub.matches")
+			}
+		})
+	}
+}
+
+func Test_stub_roundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		stub     stub
+		request  *http.Request
+		wantCode int
+		wantType string
+		wantBody string
+	}{
+		{
+			name:     "default status",
+			stub:     stub{Response: stubResponse{Body: "hello", Headers: map[string]string{"Content-Type": "text/plain"}}},
+			request:  httptest.NewRequest("GET", "/", nil),
+			wantCode: http.StatusOK,
+			wantType: "text/plain",
+			wantBody: "hello",
+		},
+		{
+			name:     "json",
+			stub:     stub{Response: stubResponse{Status: http.StatusCreated, JSON: []byte(`{"id": 1}`)}},
+			request:  httptest.NewRequest("POST", "/", nil),
+			wantCode: http.StatusCreated,
+			wantType: "application/json",
+			wantBody: `{"id": 1}`,
+		},
+		{
+			name:     "template",
+			stub:     stub{Response: stubResponse{JSON: []byte(`{"id": "{{index .Segments 1}}"}`), Template: true}},
+			request:  httptest.NewRequest("GET", "/users/42", nil),
+			wantCode: http.StatusOK,
+			wantType: "application/json",
+			wantBody: `{"id": "42"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := tt.stub.roundTrip(tt.request, slog.New(slog.DiscardHandler))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.wantCode {
+				t.Errorf("unexpected status code, got: %d, want: %d", resp.StatusCode, tt.wantCode)
+			}
+
+			if got := resp.Header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("unexpected content type, got: %s, want: %s", got, tt.wantType)
+			}
+
+			if string(body) != tt.wantBody || resp.ContentLength != int64(len(body)) {
+				t.Errorf("unexpected body, got: %s (%d), want: %s", body, resp.ContentLength, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_LoadStubs(t *testing.T) {
+	tests := []struct {
+		name      string
+		files     map[string]string
+		wantErr   bool
+		wantStubs int
+	}{
+		{
+			name:    "bad json",
+			files:   map[string]string{"users.json": "{"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid stub",
+			files:   map[string]string{"users.json": `{"path": "("}`},
+			wantErr: true,
+		},
+		{
+			name: "success",
+			files: map[string]string{
+				"users.json":  `{"method": "GET", "path": "^/users", "response": {"json": []}}`,
+				"orders.json": `[{"path": "^/orders"}, {"path": "^/payments", "response": {"status": 402}}]`,
+				"readme.txt":  "not a stub",
+			},
+			wantStubs: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, data := range tt.files {
+				if err := ioutil.WriteFile(dir+"/"+name, []byte(data), 0600); err != nil {
+					t.Fatalf("failed to write %s: %v", name, err)
+				}
+			}
+
+			rt := &RoundTripper{logger: slog.New(slog.DiscardHandler)}
+			err := rt.LoadStubs(dir)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RoundTripper.LoadStubs error = %v, wantErr: %t", err, tt.wantErr)
+			}
+
+			if len(rt.stubs) != tt.wantStubs {
+				t.Errorf("RoundTripper.LoadStubs loaded %d stubs, want: %d", len(rt.stubs), tt.wantStubs)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_Stubs(t *testing.T) {
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler)}
+
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "empty list", method: "GET", wantCode: http.StatusOK, wantBody: "[]\n"},
+		{name: "bad json", method: "POST", body: "{", wantCode: http.StatusBadRequest},
+		{name: "invalid stub", method: "POST", body: `{"path": "("}`, wantCode: http.StatusBadRequest},
+		{name: "add stub", method: "POST", body: `{"method": "GET", "path": "^/users", "response": {"status": 404}}`, wantCode: http.StatusOK},
+		{name: "list", method: "GET", wantCode: http.StatusOK, wantBody: `[{"method":"GET","path":"^/users","response":{"status":404}}]` + "\n"},
+		{name: "bad method", method: "PUT", wantCode: http.StatusMethodNotAllowed},
+		{name: "delete", method: "DELETE", wantCode: http.StatusOK},
+		{name: "deleted", method: "GET", wantCode: http.StatusOK, wantBody: "[]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.Stubs(w, httptest.NewRequest(tt.method, "/gmeter/stubs", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("unexpected status code, got: %d, want: %d", w.Code, tt.wantCode)
+			}
+
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("unexpected body, got: %s, want: %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRoundTripper_RoundTrip_stub(t *testing.T) {
+	s := stub{Path: "^/users", Response: stubResponse{Status: http.StatusTeapot}}
+	if err := s.validate(); err != nil {
+		t.Fatalf("invalid stub: %v", err)
+	}
+
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), stubs: []stub{s}}
+
+	resp, err := rt.RoundTrip(httptest.NewRequest("GET", "/users", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("unexpected status code, got: %d, want: %d", resp.StatusCode, http.StatusTeapot)
+	}
+
+	if _, err := rt.RoundTrip(httptest.NewRequest("GET", "/orders", nil)); err != errNotInitialized {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
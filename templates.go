@@ -0,0 +1,181 @@
+package gmeter
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+type (
+	//templateData is available to the templates in the played back responses
+	templateData struct {
+		Method   string
+		Path     string
+		Segments []string
+		Query    url.Values
+		Header   http.Header
+
+		//Body is the JSON body of the request decoded into
+		//map[string]interface{}, []interface{}, etc. or nil
+		Body interface{}
+	}
+)
+
+const templateAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+var templateFuncs = template.FuncMap{
+	"now":          templateNow,
+	"unix":         func() int64 { return time.Now().Unix() },
+	"uuid":         templateUUID,
+	"randomInt":    templateRandomInt,
+	"randomString": templateRandomString,
+	"json":         templateJSON,
+}
+
+//renderTemplates returns govcr.ResponseFilterFunc that executes the templates
+//in the header values and the body of the played back response, the parts that
+//fail to render are left untouched
+func renderTemplates(logger *slog.Logger) govcr.ResponseFilterFunc {
+	return func(respHeader http.Header, body []byte, req *http.Request) (*http.Header, *[]byte) {
+		data, err := newTemplateData(req)
+		if err != nil {
+			logger.Error("failed to render response templates", "method", req.Method, "url", req.URL.String(), "error", err)
+			return &respHeader, &body
+		}
+
+		//the header is shared with the track on the cassette
+		header := make(http.Header, len(respHeader))
+		for k, values := range respHeader {
+			for _, v := range values {
+				rendered, err := renderTemplate(v, data)
+				if err != nil {
+					logger.Error("failed to render header template", "method", req.Method, "url", req.URL.String(), "header", k, "error", err)
+					rendered = v
+				}
+				header[k] = append(header[k], rendered)
+			}
+		}
+
+		rendered, err := renderTemplate(string(body), data)
+		if err != nil {
+			logger.Error("failed to render response body template", "method", req.Method, "url", req.URL.String(), "error", err)
+			return &header, &body
+		}
+
+		renderedBody := []byte(rendered)
+		return &header, &renderedBody
+	}
+}
+
+func newTemplateData(r *http.Request) (*templateData, error) {
+	data := &templateData{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header,
+	}
+
+	for _, segment := range strings.Split(r.URL.Path, "/") {
+		if segment != "" {
+			data.Segments = append(data.Segments, segment)
+		}
+	}
+
+	if r.Body == nil {
+		return data, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %v", err)
+	}
+
+	if len(bytes.TrimSpace(body)) > 0 && json.Valid(body) {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data.Body); err != nil {
+			return nil, fmt.Errorf("failed to decode request body: %v", err)
+		}
+	}
+
+	return data, nil
+}
+
+//renderTemplate executes the template, strings without actions are returned as is
+func renderTemplate(text string, data *templateData) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New("response").Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+//templateNow returns the current time in RFC3339 or in the given layout
+func templateNow(layout ...string) string {
+	if len(layout) > 0 {
+		return time.Now().Format(layout[0])
+	}
+
+	return time.Now().Format(time.RFC3339)
+}
+
+//templateUUID returns a random (version 4) UUID
+func templateUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+//templateRandomInt returns a random number in [min, max)
+func templateRandomInt(min, max int) (int, error) {
+	if max <= min {
+		return 0, fmt.Errorf("randomInt: max should be greater than min")
+	}
+
+	return min + mathrand.Intn(max-min), nil
+}
+
+//templateRandomString returns a random alphanumeric string of length n
+func templateRandomString(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = templateAlphabet[mathrand.Intn(len(templateAlphabet))]
+	}
+
+	return string(b)
+}
+
+//templateJSON encodes the value as JSON, e.g. to echo the part of the request body
+func templateJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,147 @@
+package gmeter
+
+import (
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hexdigest/gmeter/internal/govcr"
+)
+
+func Test_renderTemplates(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+		req    func() *http.Request
+
+		wantHeader http.Header
+		wantBody   *regexp.Regexp
+	}{
+		{
+			name:       "no templates",
+			header:     http.Header{"Content-Type": {"application/json"}},
+			body:       `{"id": 1}`,
+			req:        func() *http.Request { return httptest.NewRequest("GET", "/users/1", nil) },
+			wantHeader: http.Header{"Content-Type": {"application/json"}},
+			wantBody:   regexp.MustCompile(`^\{"id": 1\}$`),
+		},
+		{
+			name:   "request data",
+			header: http.Header{"X-Request-Id": {`{{.Header.Get "X-Request-Id"}}`}},
+			body:   `{"user": "{{index .Segments 1}}", "page": "{{.Query.Get "page"}}", "name": {{json .Body.name}}, "method": "{{.Method}}"}`,
+			req: func() *http.Request {
+				r := httptest.NewRequest("POST", "/users/42?page=2", strings.NewReader(`{"name": "gmeter"}`))
+				r.Header.Set("X-Request-Id", "abc")
+				return r
+			},
+			wantHeader: http.Header{"X-Request-Id": {"abc"}},
+			wantBody:   regexp.MustCompile(`^\{"user": "42", "page": "2", "name": "gmeter", "method": "POST"\}$`),
+		},
+		{
+			name:       "helpers",
+			body:       `{{uuid}} {{now "2006"}} {{unix}} {{randomInt 5 6}} {{randomString 8}}`,
+			req:        func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			wantHeader: http.Header{},
+			wantBody:   regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12} \d{4} \d+ 5 [a-zA-Z0-9]{8}$`),
+		},
+		{
+			name:       "missing body field",
+			body:       `{{.Body.missing}}`,
+			req:        func() *http.Request { return httptest.NewRequest("POST", "/", strings.NewReader(`{}`)) },
+			wantHeader: http.Header{},
+			wantBody:   regexp.MustCompile(`^\{\{\.Body\.missing\}\}$`),
+		},
+		{
+			name:       "bad template",
+			header:     http.Header{"X-Id": {"{{.Nope"}},
+			body:       `{{if}}`,
+			req:        func() *http.Request { return httptest.NewRequest("GET", "/", nil) },
+			wantHeader: http.Header{"X-Id": {"{{.Nope"}},
+			wantBody:   regexp.MustCompile(`^\{\{if\}\}$`),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := renderTemplates(slog.New(slog.DiscardHandler))
+			gotHeader, gotBody := filter(tt.header, []byte(tt.body), tt.req())
+
+			if len(*gotHeader) != len(tt.wantHeader) {
+				t.Errorf("renderTemplates header = %v, want: %v", *gotHeader, tt.wantHeader)
+			}
+
+			for k := range tt.wantHeader {
+				if gotHeader.Get(k) != tt.wantHeader.Get(k) {
+					t.Errorf("renderTemplates header %s = %q, want: %q", k, gotHeader.Get(k), tt.wantHeader.Get(k))
+				}
+			}
+
+			if !tt.wantBody.Match(*gotBody) {
+				t.Errorf("renderTemplates body = %s, want: %s", *gotBody, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_renderTemplates_chunked(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, part := range []string{`{"id": "{{index .Segments 1}}", `, `"name": {{json .Body.name}}}`} {
+			io.WriteString(w, part)
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	defer target.Close()
+
+	dir := t.TempDir()
+
+	newRequest := func() *http.Request {
+		r, _ := http.NewRequest("POST", target.URL+"/users/42", strings.NewReader(`{"name": "gmeter"}`))
+		return r
+	}
+
+	recorder := govcr.NewVCR("chunked", &govcr.VCRConfig{CassettePath: dir, Logger: slog.New(slog.DiscardHandler)})
+	resp, err := recorder.Client.Do(newRequest())
+	if err != nil {
+		t.Fatalf("failed to record: %v", err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	player := govcr.NewVCR("chunked", &govcr.VCRConfig{
+		CassettePath:       dir,
+		DisableRecording:   true,
+		Logger:             slog.New(slog.DiscardHandler),
+		ResponseFilterFunc: renderTemplates(slog.New(slog.DiscardHandler)),
+	})
+
+	resp, err = player.Client.Do(newRequest())
+	if err != nil {
+		t.Fatalf("failed to play: %v", err)
+	}
+	defer resp.Body.Close()
+
+	//each chunk is rendered separately
+	var chunks []string
+	buf := make([]byte, 1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			chunks = append(chunks, string(buf[:n]))
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if want := []string{`{"id": "42", `, `"name": "gmeter"}`}; strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("played back chunks = %q, want: %q", chunks, want)
+	}
+}
@@ -0,0 +1,78 @@
+package gmeter
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+//newTransport returns a transport that is used to make requests to the target
+func newTransport(options Options) (*http.Transport, error) {
+	tlsConfig, err := upstreamTLSConfig(options)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if options.UpstreamProxy != nil {
+		proxy = http.ProxyURL(options.UpstreamProxy)
+	}
+
+	transport := &http.Transport{
+		Proxy:             proxy,
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+	}
+
+	//gRPC requires HTTP/2, the transport negotiates it over TLS
+	//but it can't upgrade cleartext connections on its own
+	if options.H2C {
+		transport.Protocols = new(http.Protocols)
+		transport.Protocols.SetHTTP2(true)
+		transport.Protocols.SetUnencryptedHTTP2(true)
+	}
+
+	return transport, nil
+}
+
+func upstreamTLSConfig(options Options) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: options.Insecure,
+		MinVersion:         options.TLSMinVersion,
+		ServerName:         options.ServerName,
+	}
+
+	if options.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(options.ClientCertFile, options.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(options.RootCAFiles) == 0 {
+		return config, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+
+	for _, filename := range options.RootCAFiles {
+		pem, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file: %s", filename)
+		}
+	}
+
+	config.RootCAs = pool
+
+	return config, nil
+}
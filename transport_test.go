@@ -0,0 +1,194 @@
+package gmeter
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name string, data []byte) string {
+	filename := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(filename, data, 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", filename, err)
+	}
+	return filename
+}
+
+func Test_newTransport(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		request *http.Request
+
+		wantProxy *url.URL
+		wantErr   bool
+	}{
+		{
+			name:    "bad TLS options",
+			options: Options{RootCAFiles: []string{filepath.Join(os.TempDir(), "missing.pem")}},
+			wantErr: true,
+		},
+		{
+			name:      "explicit proxy",
+			options:   Options{UpstreamProxy: &url.URL{Scheme: "socks5", Host: "proxy.local:1080"}},
+			request:   httptest.NewRequest("GET", "https://github.com/hexdigest/gmeter", nil),
+			wantProxy: &url.URL{Scheme: "socks5", Host: "proxy.local:1080"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got1, err := newTransport(tt.options)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTransport error = %v, wantErr: %t", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			proxy, err := got1.Proxy(tt.request)
+			if err != nil {
+				t.Fatalf("unexpected proxy error: %v", err)
+			}
+
+			if !reflect.DeepEqual(proxy, tt.wantProxy) {
+				t.Errorf("newTransport proxy = %v, want: %v", proxy, tt.wantProxy)
+			}
+		})
+	}
+}
+
+func Test_newTransport_protocols(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+
+		wantUnencryptedHTTP2 bool
+	}{
+		{name: "default", options: Options{}},
+		{name: "h2c", options: Options{H2C: true}, wantUnencryptedHTTP2: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got1, err := newTransport(tt.options)
+			if err != nil {
+				t.Fatalf("newTransport error = %v", err)
+			}
+
+			if !got1.ForceAttemptHTTP2 {
+				t.Errorf("newTransport doesn't attempt HTTP/2")
+			}
+
+			if got := got1.Protocols != nil && got1.Protocols.UnencryptedHTTP2(); got != tt.wantUnencryptedHTTP2 {
+				t.Errorf("newTransport unencrypted HTTP/2 = %t, want: %t", got, tt.wantUnencryptedHTTP2)
+			}
+		})
+	}
+}
+
+func Test_upstreamTLSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		options func(t *testing.T) Options
+		inspect func(c *tls.Config, t *testing.T)
+
+		wantErr    bool
+		inspectErr func(err error, t *testing.T) //use for more precise error evaluation after test
+	}{
+		{
+			name: "defaults",
+			options: func(t *testing.T) Options {
+				return Options{Insecure: true, TLSMinVersion: tls.VersionTLS12, ServerName: "github.com"}
+			},
+			inspect: func(c *tls.Config, t *testing.T) {
+				if !c.InsecureSkipVerify || c.MinVersion != tls.VersionTLS12 || c.ServerName != "github.com" {
+					t.Errorf("unexpected config: %+v", c)
+				}
+				if c.RootCAs != nil || len(c.Certificates) != 0 {
+					t.Errorf("unexpected certificates: %+v", c)
+				}
+			},
+		},
+		{
+			name: "missing client certificate",
+			options: func(t *testing.T) Options {
+				return Options{ClientCertFile: "missing.crt", ClientKeyFile: "missing.key"}
+			},
+			wantErr: true,
+			inspectErr: func(err error, t *testing.T) {
+				if !strings.Contains(err.Error(), "failed to load client certificate") {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "missing CA file",
+			options: func(t *testing.T) Options {
+				return Options{RootCAFiles: []string{filepath.Join(os.TempDir(), "missing.pem")}}
+			},
+			wantErr: true,
+			inspectErr: func(err error, t *testing.T) {
+				if !strings.Contains(err.Error(), "failed to read CA file") {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "empty CA file",
+			options: func(t *testing.T) Options {
+				return Options{RootCAFiles: []string{writeTempFile(t, "ca.pem", []byte("garbage"))}}
+			},
+			wantErr: true,
+			inspectErr: func(err error, t *testing.T) {
+				if !strings.Contains(err.Error(), "no certificates found") {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
+		{
+			name: "success",
+			options: func(t *testing.T) Options {
+				cert, err := selfSignedCertificate("localhost:8080")
+				if err != nil {
+					t.Fatalf("failed to generate certificate: %v", err)
+				}
+				data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+				return Options{RootCAFiles: []string{writeTempFile(t, "ca.pem", data)}}
+			},
+			inspect: func(c *tls.Config, t *testing.T) {
+				if c.RootCAs == nil {
+					t.Errorf("expected custom CA pool")
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got1, err := upstreamTLSConfig(tt.options(t))
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("upstreamTLSConfig error = %v, wantErr: %t", err, tt.wantErr)
+			}
+
+			if tt.inspectErr != nil {
+				tt.inspectErr(err, t)
+			}
+
+			if tt.inspect != nil {
+				tt.inspect(got1, t)
+			}
+		})
+	}
+}
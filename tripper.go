@@ -6,16 +6,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"strconv"
 	"sync"
+	"time"
 
-	"github.com/seborama/govcr"
+	"github.com/hexdigest/gmeter/internal/govcr"
 )
 
 var (
-	errNotInitialized = errors.New("gmeter is not initialized, please call /gmeter/record or /gmeter/play first")
+	errNotInitialized = errors.New("gmeter is not initialized, please call /gmeter/record, /gmeter/play or /gmeter/passthrough first or add a stub that matches the request")
 )
 
 type (
@@ -24,17 +28,70 @@ type (
 		http.RoundTripper
 
 		lock    sync.RWMutex
-		logger  *log.Logger
+		logger  *slog.Logger
 		options Options
+		faults  []faultRule
+		stubs   []stub
+
+		//vcr is the control panel of the cassette in record and play modes
+		vcr *govcr.VCRControlPanel
+
+		//miss is the response to the requests that are not found in Play mode
+		miss *miss
+
+		//session, mode and cassette describe the current session
+		session  string
+		mode     string
+		cassette string
+
+		//target is the target of the current session, it's taken from
+		//the options when the session starts
+		target *url.URL
+
+		metrics metrics
+		journal journal
+		summary summary
 	}
 
 	request struct {
 		Cassette string `json:"cassette"`
+
+		//Latency is used in Play mode to delay the responses
+		Latency *latency `json:"latency"`
+
+		//GraphQL enables matching of GraphQL requests by their meaning
+		//rather than by the exact body
+		GraphQL *graphQL `json:"graphql"`
+
+		//Templates enables rendering of the templates in the headers and the bodies
+		//of the played back responses
+		Templates bool `json:"templates"`
+
+		//Sequential makes Play mode check that the requests come in the recorded order
+		Sequential bool `json:"sequential"`
+
+		//Miss describes the response to the requests that are not found on the cassette in Play mode
+		Miss *miss `json:"miss"`
 	}
 
 	nopTripper struct{}
+
+	//trackNotFoundError is returned in Play mode when there is no track for the request,
+	//it lists the tracks that are closest to the request along with their diffs
+	trackNotFoundError struct {
+		dump    string
+		closest []govcr.TrackDiff
+	}
+
+	//roundTripperFunc is an adapter to use ordinary functions as http.RoundTripper
+	roundTripperFunc func(*http.Request) (*http.Response, error)
 )
 
+//RoundTrip implements http.RoundTripper
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
 //RoundTrip implements http.RoundTripper that always returns an error
 //it's used in Play mode so that responses can only be replayed but not recorded
 func (nt nopTripper) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -42,29 +99,181 @@ func (nt nopTripper) RoundTrip(r *http.Request) (*http.Response, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to dump request: %v", err)
 	}
-	return nil, fmt.Errorf("track not found for request: %s", string(b))
+	return nil, &trackNotFoundError{dump: string(b), closest: govcr.ClosestTracks(r.Context(), closestTracks)}
+}
+
+//closestTracks is the number of the closest tracks reported when a track is not found
+const closestTracks = 3
+
+func (e *trackNotFoundError) Error() string {
+	msg := "track not found for request: " + e.dump
+	if len(e.closest) == 0 {
+		return msg
+	}
+
+	msg += "\nclosest tracks:\n"
+	for _, d := range e.closest {
+		msg += d.String()
+	}
+
+	return msg
 }
 
 //NewRoundTripper returns a pointer to RoundTripper struct
-func NewRoundTripper(options Options, logger *log.Logger) *RoundTripper {
-	return &RoundTripper{options: options, logger: logger}
+func NewRoundTripper(options Options, logger *slog.Logger) *RoundTripper {
+	return &RoundTripper{options: options, logger: logger, target: options.TargetURL}
+}
+
+//Target returns the target of the current session
+func (rt *RoundTripper) Target() *url.URL {
+	rt.lock.RLock()
+	defer rt.lock.RUnlock()
+
+	return rt.target
 }
 
 //RoundTrip implements http.RoundTripper
 func (rt *RoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	//the faults, the stubs and the replay can delay the exchange for a long time
+	//so it's served with a copy of the session rather than under the lock
 	rt.lock.RLock()
-	defer rt.lock.RUnlock()
+	var (
+		transport = rt.RoundTripper
+		faults    = rt.faults
+		stubs     = rt.stubs
+		ex        = &exchange{session: rt.session, mode: rt.mode, cassette: rt.cassette}
+	)
+	rt.lock.RUnlock()
 
-	if rt.RoundTripper == nil {
-		return nil, errNotInitialized
+	var (
+		resp  *http.Response
+		err   error
+		start = time.Now()
+	)
+
+	next := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return rt.next(r, stubs, transport)
+	})
+
+	r = withExchange(r, ex)
+
+	var reqBody *capture
+	if r.Body != nil && r.Body != http.NoBody {
+		reqBody = newCapture(r.Body, func() {})
+		r.Body = reqBody
+	}
+
+	if rule := matchFault(faults, r); rule != nil {
+		ex.fault = true
+		resp, err = rule.roundTrip(r, next)
+	} else {
+		resp, err = next(r)
 	}
 
-	resp, err := rt.RoundTripper.RoundTrip(r)
+	rt.logExchange(r, resp, err, ex, time.Since(start))
+
+	status := "error"
 	if resp != nil {
-		rt.logger.Printf("%s %s %d", r.Method, r.URL, resp.StatusCode)
+		status = strconv.Itoa(resp.StatusCode)
+	}
+
+	rt.metrics.countRequest(ex.mode, ex.cassette, r.Method, status)
+	rt.summary.count(ex.result())
+
+	switch ex.outcome.Result {
+	case govcr.ResultPlayed:
+		rt.metrics.countHit(ex.cassette)
+	case govcr.ResultMissed:
+		rt.metrics.countMiss(ex.cassette)
+	}
+
+	return rt.journalExchange(r, resp, err, ex, start, reqBody), err
+}
+
+//next serves the request with the matching stub, otherwise the request is
+//recorded, played or passed through by the transport of the session
+func (rt *RoundTripper) next(r *http.Request, stubs []stub, transport http.RoundTripper) (*http.Response, error) {
+	if s := matchStub(stubs, r); s != nil {
+		exchangeFrom(r).stub = true
+		return s.roundTrip(r, rt.logger)
+	}
+
+	if transport == nil {
+		return nil, errNotInitialized
+	}
+
+	return transport.RoundTrip(r)
+}
+
+//ErrorHandler handles errors returned by the RoundTrip, it's meant to be used
+//as httputil.ReverseProxy.ErrorHandler
+func (rt *RoundTripper) ErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if err == errConnectionReset {
+		rt.logger.Debug("connection reset", "method", r.Method, "url", r.URL.String())
+		resetConnection(w)
+		return
+	}
+
+	var trackErr *govcr.TrackError
+	if errors.As(err, &trackErr) {
+		if f := classifyFailure(trackErr); f != failureUnknown {
+			rt.logger.Debug("replaying recorded failure", "method", r.Method, "url", r.URL.String(), "failure", f.String())
+			replayFailure(w, r, f)
+			return
+		}
+	}
+
+	var (
+		seqErr   *govcr.SequenceError
+		notFound *trackNotFoundError
+	)
+
+	if errors.As(err, &seqErr) || errors.As(err, &notFound) {
+		//the client should see why the request can't be played back,
+		//the error is already logged by RoundTrip
+		rt.lock.RLock()
+		m := rt.miss
+		rt.lock.RUnlock()
+
+		m.write(w, r, err)
+		return
+	}
+
+	rt.logger.Error("proxy error", "method", r.Method, "url", r.URL.String(), "error", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+//hijack takes over the client connection, for HTTPs connections the
+//underlying TCP connection is returned
+func hijack(w http.ResponseWriter) net.Conn {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		//HTTP/2 streams can't be hijacked, aborting the handler resets the stream
+		panic(http.ErrAbortHandler)
+	}
+
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.NetConn()
+	}
+
+	return conn
+}
+
+//resetConnection closes the client connection without sending a response,
+//on TCP connections RST is sent instead of FIN
+func resetConnection(w http.ResponseWriter) {
+	conn := hijack(w)
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
 	}
 
-	return resp, err
+	conn.Close()
 }
 
 //Record starts recording of a cassette
@@ -74,25 +283,49 @@ func (rt *RoundTripper) Record(w http.ResponseWriter, r *http.Request) {
 
 	req, err := decodeRequest(r.Body)
 	if err != nil {
-		rt.logger.Printf("record failed: %v", err)
+		rt.logger.Error("record failed", "error", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	if err := rt.record(req); err != nil {
+		rt.logger.Error("record failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//record starts recording of the cassette, rt.lock should be held by the caller
+func (rt *RoundTripper) record(req *request) error {
+	transport, err := newTransport(rt.options)
+	if err != nil {
+		return err
+	}
+
+	session := newSessionID()
+
 	config := govcr.VCRConfig{
-		DisableRecording: false,
-		CassettePath:     rt.options.CassettePath,
-		Client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: rt.options.Insecure,
-				},
-			},
-		},
+		DisableRecording:   false,
+		CassettePath:       rt.options.CassettePath,
+		Logger:             rt.logger.With("session", session),
+		Client:             &http.Client{Transport: rt.instrumentUpstream(transport, modeRecord)},
+		RecordingErrorFunc: rt.recordingFailed(req.Cassette),
+	}
+
+	if req.GraphQL != nil {
+		config.RequestFilterFunc = req.GraphQL.filter
+	}
+
+	if req.Templates {
+		config.ResponseFilterFunc = renderTemplates(rt.logger)
 	}
 
-	rt.RoundTripper = govcr.NewVCR(req.Cassette, &config).Client.Transport
-	rt.logger.Printf("started recording of the cassette: %s", req.Cassette)
+	rt.vcr = govcr.NewVCR(req.Cassette, &config)
+	rt.RoundTripper = rt.vcr.Client.Transport
+	rt.miss = nil
+	rt.session, rt.mode, rt.cassette = session, modeRecord, req.Cassette
+	rt.target = rt.options.TargetURL
+	rt.logger.Info("started recording", "session", session, "cassette", req.Cassette)
+	return nil
 }
 
 //Play stops recording and starts playing a cassette
@@ -102,21 +335,113 @@ func (rt *RoundTripper) Play(w http.ResponseWriter, r *http.Request) {
 
 	req, err := decodeRequest(r.Body)
 	if err != nil {
-		rt.logger.Printf("play failed: %v", err)
+		rt.logger.Error("play failed", "error", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	if err := rt.play(req); err != nil {
+		rt.logger.Error("play failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//play starts playing of the cassette, rt.lock should be held by the caller
+func (rt *RoundTripper) play(req *request) error {
+	transport, err := req.Miss.transport(rt.options, rt.logger)
+	if err != nil {
+		return err
+	}
+
+	if req.Miss != nil && req.Miss.Upstream {
+		transport = rt.instrumentUpstream(transport, modePlay)
+	}
+
+	session := newSessionID()
+
 	config := govcr.VCRConfig{
 		DisableRecording: true,
 		CassettePath:     rt.options.CassettePath,
+		Logger:           rt.logger.With("session", session),
 		Client: &http.Client{
-			Transport: nopTripper{},
+			Transport: transport,
 		},
+		Sequential: req.Sequential,
 	}
 
-	rt.RoundTripper = govcr.NewVCR(req.Cassette, &config).Client.Transport
-	rt.logger.Printf("started playing the cassette: %s", req.Cassette)
+	if req.Latency != nil {
+		config.LatencyFunc = req.Latency.timing
+		config.ChunkDelayFunc = req.Latency.chunkDelay
+	}
+
+	if req.GraphQL != nil {
+		config.RequestFilterFunc = req.GraphQL.filter
+	}
+
+	if req.Templates {
+		config.ResponseFilterFunc = renderTemplates(rt.logger)
+	}
+
+	rt.vcr = govcr.NewVCR(req.Cassette, &config)
+	rt.RoundTripper = rt.vcr.Client.Transport
+	rt.miss = req.Miss
+	rt.session, rt.mode, rt.cassette = session, modePlay, req.Cassette
+	rt.target = rt.options.TargetURL
+	rt.logger.Info("started playing", "session", session, "cassette", req.Cassette)
+	return nil
+}
+
+//Passthrough stops recording or playing and starts passing requests to the target
+func (rt *RoundTripper) Passthrough(w http.ResponseWriter, r *http.Request) {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	if err := rt.passthrough(); err != nil {
+		rt.logger.Error("passthrough failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+//passthrough starts passing requests to the target, rt.lock should be held by the caller
+func (rt *RoundTripper) passthrough() error {
+	transport, err := newTransport(rt.options)
+	if err != nil {
+		return err
+	}
+
+	rt.RoundTripper = rt.instrumentUpstream(transport, modePassthrough)
+	rt.vcr = nil
+	rt.miss = nil
+	rt.session, rt.mode, rt.cassette = newSessionID(), modePassthrough, ""
+	rt.target = rt.options.TargetURL
+	rt.logger.Info("started passing requests through to the target", "session", rt.session)
+	return nil
+}
+
+//Start starts the session in the mode set in the options (if any) so that
+//gmeter is ready to serve requests without calling /gmeter/record, /gmeter/play
+//or /gmeter/passthrough first
+func (rt *RoundTripper) Start() error {
+	rt.lock.Lock()
+	defer rt.lock.Unlock()
+
+	switch rt.options.Mode {
+	case modeRecord:
+		return rt.record(&request{Cassette: rt.options.Cassette})
+	case modePlay:
+		return rt.play(&request{Cassette: rt.options.Cassette})
+	case modePassthrough:
+		return rt.passthrough()
+	}
+
+	return nil
+}
+
+//recordingFailed returns govcr.RecordingErrorFunc that counts the failures
+func (rt *RoundTripper) recordingFailed(cassette string) govcr.RecordingErrorFunc {
+	return func(err error) {
+		rt.metrics.countRecordingFailure(cassette)
+	}
 }
 
 var errEmptyCassette = errors.New("empty cassette name")
@@ -132,5 +457,17 @@ func decodeRequest(r io.Reader) (*request, error) {
 		return nil, errEmptyCassette
 	}
 
+	if req.Latency != nil {
+		if err := req.Latency.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Miss != nil {
+		if err := req.Miss.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	return &req, nil
 }
@@ -3,8 +3,7 @@ package gmeter
 import (
 	"errors"
 	"io"
-	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
@@ -119,7 +118,7 @@ func Test_RoundTripper_Record(t *testing.T) {
 		{
 			name: "bad request",
 			init: func(*testing.T) *RoundTripper {
-				return &RoundTripper{logger: log.New(ioutil.Discard, "", 0)}
+				return &RoundTripper{logger: slog.New(slog.DiscardHandler)}
 			},
 			args: func(t *testing.T) args {
 				return args{
@@ -128,10 +127,26 @@ func Test_RoundTripper_Record(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "bad transport options",
+			init: func(*testing.T) *RoundTripper {
+				return &RoundTripper{
+					logger:  slog.New(slog.DiscardHandler),
+					options: Options{ClientCertFile: "missing.crt", ClientKeyFile: "missing.key"},
+				}
+			},
+			args: func(t *testing.T) args {
+				body := strings.NewReader(`{"cassette": "nice music"}`)
+				return args{
+					r: httptest.NewRequest("POST", "https://github.com/hexdigest/gmeter", body),
+					w: newCheckStatusWriter(t, 500),
+				}
+			},
+		},
 		{
 			name: "success",
 			init: func(*testing.T) *RoundTripper {
-				return &RoundTripper{logger: log.New(ioutil.Discard, "", 0)}
+				return &RoundTripper{logger: slog.New(slog.DiscardHandler)}
 			},
 			args: func(t *testing.T) args {
 				body := strings.NewReader(`{"cassette": "nice music"}`)
@@ -188,6 +203,20 @@ func Test_decodeRequest(t *testing.T) {
 			},
 			want1: &request{Cassette: "nice music"},
 		},
+		{
+			name: "bad latency",
+			args: func(t *testing.T) args {
+				return args{r: strings.NewReader(`{"cassette": "nice music", "latency": {"mode": "random", "min": "1s", "max": "1ms"}}`)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "latency",
+			args: func(t *testing.T) args {
+				return args{r: strings.NewReader(`{"cassette": "nice music", "latency": {"mode": "recorded", "scale": 1.5}}`)}
+			},
+			want1: &request{Cassette: "nice music", Latency: &latency{Mode: latencyRecorded, Scale: 1.5}},
+		},
 	}
 
 	for _, tt := range tests {
@@ -226,7 +255,7 @@ func Test_RoundTripper_Play(t *testing.T) {
 		{
 			name: "bad request",
 			init: func(*testing.T) *RoundTripper {
-				return &RoundTripper{logger: log.New(ioutil.Discard, "", 0)}
+				return &RoundTripper{logger: slog.New(slog.DiscardHandler)}
 			},
 			args: func(t *testing.T) args {
 				r, _ := http.NewRequest("POST", "https://github.com/hexdigest/gmeter", strings.NewReader("{"))
@@ -239,7 +268,7 @@ func Test_RoundTripper_Play(t *testing.T) {
 		{
 			name: "success",
 			init: func(*testing.T) *RoundTripper {
-				return &RoundTripper{logger: log.New(ioutil.Discard, "", 0)}
+				return &RoundTripper{logger: slog.New(slog.DiscardHandler)}
 			},
 			args: func(t *testing.T) args {
 				body := strings.NewReader(`{"cassette": "nice music"}`)
@@ -263,11 +292,139 @@ func Test_RoundTripper_Play(t *testing.T) {
 	}
 }
 
+func TestRoundTripper_Play_sequential(t *testing.T) {
+	dir := writeCassette(t, "sequence",
+		cassetteTrack("GET", "/first", "1", "", "", ""),
+		cassetteTrack("GET", "/second", "2", "", "", ""),
+	)
+
+	tests := []struct {
+		name     string
+		paths    []string
+		wantCode []int
+		wantBody string
+	}{
+		{
+			name:     "in order",
+			paths:    []string{"/first", "/second"},
+			wantCode: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name:     "out of order",
+			paths:    []string{"/second"},
+			wantCode: []int{http.StatusBadGateway},
+			wantBody: "out of sequence request, expected track #0:\nGET /first HTTP/1.1",
+		},
+		{
+			name:     "extra call",
+			paths:    []string{"/first", "/second", "/first"},
+			wantCode: []int{http.StatusOK, http.StatusOK, http.StatusBadGateway},
+			wantBody: "unexpected request, all 2 tracks have been played",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: Options{CassettePath: dir}}
+			rt.Play(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmeter/play", strings.NewReader(`{"cassette": "sequence", "sequential": true}`)))
+
+			for i, path := range tt.paths {
+				w := httptest.NewRecorder()
+				r := httptest.NewRequest("GET", "http://example.com"+path, nil)
+
+				resp, err := rt.RoundTrip(r)
+				if err != nil {
+					rt.ErrorHandler(w, r, err)
+				} else {
+					w.WriteHeader(resp.StatusCode)
+				}
+
+				if w.Code != tt.wantCode[i] {
+					t.Errorf("request %d: unexpected status code, got: %d, want: %d", i, w.Code, tt.wantCode[i])
+				}
+
+				if tt.wantCode[i] != http.StatusOK && !strings.Contains(w.Body.String(), tt.wantBody) {
+					t.Errorf("request %d: unexpected body, got: %s, want: %s", i, w.Body.String(), tt.wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripper_Play_closestTracks(t *testing.T) {
+	dir := writeCassette(t, "diagnostics",
+		cassetteTrack("GET", "/users", "[]", "", "", ""),
+		cassetteTrack("POST", "/orders", "{}", "", "", ""),
+	)
+
+	rt := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: Options{CassettePath: dir}}
+	rt.Play(httptest.NewRecorder(), httptest.NewRequest("POST", "/gmeter/play", strings.NewReader(`{"cassette": "diagnostics"}`)))
+
+	r := httptest.NewRequest("GET", "http://example.com/users?page=2", nil)
+	r.Header.Set("Accept", "application/json")
+
+	_, err := rt.RoundTrip(r)
+	if err == nil {
+		t.Fatalf("expected track not found error")
+	}
+
+	w := httptest.NewRecorder()
+	rt.ErrorHandler(w, r, err)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("unexpected status code: %d", w.Code)
+	}
+
+	for _, want := range []string{
+		"track not found for request: GET http://example.com/users?page=2",
+		"closest tracks:\ntrack #0 (score 0.77):\n  query: expected \"\", got \"page=2\"\n  header Accept: expected \"\", got \"application/json\"\n",
+		"track #1 (score 0.31):\n  method: expected \"POST\", got \"GET\"\n  path: expected \"/orders\", got \"/users\"\n",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("response body doesn't contain: %s\n%s", want, w.Body.String())
+		}
+	}
+}
+
+func TestRoundTripper_Passthrough(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		wantCode int
+	}{
+		{
+			name:     "bad transport options",
+			options:  Options{ClientCertFile: "missing.crt", ClientKeyFile: "missing.key"},
+			wantCode: http.StatusInternalServerError,
+		},
+		{
+			name:     "success",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &RoundTripper{logger: slog.New(slog.DiscardHandler), options: tt.options}
+
+			w := httptest.NewRecorder()
+			receiver.Passthrough(w, httptest.NewRequest("POST", "/gmeter/passthrough", nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("unexpected status code, got: %d, want: %d", w.Code, tt.wantCode)
+			}
+
+			if (receiver.RoundTripper != nil) != (tt.wantCode == http.StatusOK) {
+				t.Errorf("unexpected RoundTripper: %v", receiver.RoundTripper)
+			}
+		})
+	}
+}
+
 func TestNewRoundTripper(t *testing.T) {
-	expected := RoundTripper{}
 	rt := NewRoundTripper(Options{}, nil)
-	if *rt != expected {
-		t.Errorf("expected pointer to empty RoundTripper, got: %v", *rt)
+	if rt.RoundTripper != nil || rt.logger != nil || !reflect.DeepEqual(rt.options, Options{}) {
+		t.Errorf("expected pointer to empty RoundTripper, got: %+v", rt)
 	}
 }
 
@@ -297,7 +454,7 @@ func TestRoundTripper_RoundTrip(t *testing.T) {
 	}{
 		{
 			name: "not initialized",
-			init: func(t *testing.T) *RoundTripper { return &RoundTripper{} },
+			init: func(t *testing.T) *RoundTripper { return &RoundTripper{logger: slog.New(slog.DiscardHandler)} },
 			args: func(t *testing.T) args {
 				return args{r: httptest.NewRequest("POST", "http://github.com/hexdigest/gmeter", strings.NewReader(""))}
 			},
@@ -314,7 +471,7 @@ func TestRoundTripper_RoundTrip(t *testing.T) {
 				rtMock := roundTripperMock{resp: &http.Response{StatusCode: http.StatusTeapot}, err: nil}
 				return &RoundTripper{
 					RoundTripper: rtMock,
-					logger:       log.New(ioutil.Discard, "", 0),
+					logger:       slog.New(slog.DiscardHandler),
 				}
 			},
 			args: func(t *testing.T) args {
@@ -322,6 +479,30 @@ func TestRoundTripper_RoundTrip(t *testing.T) {
 			},
 			want1: &http.Response{StatusCode: http.StatusTeapot},
 		},
+		{
+			name: "fault",
+			init: func(t *testing.T) *RoundTripper {
+				rule := faultRule{Path: "^/hexdigest", Reset: true}
+				if err := rule.validate(); err != nil {
+					t.Fatalf("invalid rule: %v", err)
+				}
+
+				return &RoundTripper{
+					RoundTripper: roundTripperMock{resp: &http.Response{StatusCode: http.StatusTeapot}},
+					logger:       slog.New(slog.DiscardHandler),
+					faults:       []faultRule{rule},
+				}
+			},
+			args: func(t *testing.T) args {
+				return args{r: httptest.NewRequest("POST", "http://github.com/hexdigest/gmeter", strings.NewReader(""))}
+			},
+			wantErr: true,
+			inspectErr: func(err error, t *testing.T) {
+				if err != errConnectionReset {
+					t.Errorf("unexpected error: %v", err)
+				}
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -349,3 +530,48 @@ func TestRoundTripper_RoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestRoundTripper_Start(t *testing.T) {
+	dir := writeCassette(t, "users", cassetteTrack("GET", "/users", "[]", "", "", ""))
+
+	tests := []struct {
+		name     string
+		options  Options
+		wantMode string
+		wantErr  bool
+	}{
+		{name: "no mode", options: Options{CassettePath: dir}},
+		{name: "play", options: Options{CassettePath: dir, Mode: modePlay, Cassette: "users"}, wantMode: modePlay},
+		{name: "record", options: Options{CassettePath: dir, Mode: modeRecord, Cassette: "orders"}, wantMode: modeRecord},
+		{name: "passthrough", options: Options{CassettePath: dir, Mode: modePassthrough}, wantMode: modePassthrough},
+		{
+			name:    "bad transport options",
+			options: Options{CassettePath: dir, Mode: modeRecord, Cassette: "orders", ClientCertFile: "missing.crt", ClientKeyFile: "missing.key"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := NewRoundTripper(tt.options, slog.New(slog.DiscardHandler))
+
+			err := rt.Start()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Start error = %v, wantErr: %t", err, tt.wantErr)
+			}
+
+			if err == nil && (rt.mode != tt.wantMode || rt.cassette != tt.options.Cassette) {
+				t.Errorf("unexpected session: %q %q", rt.mode, rt.cassette)
+			}
+		})
+	}
+
+	rt := NewRoundTripper(Options{CassettePath: dir, Mode: modePlay, Cassette: "users"}, slog.New(slog.DiscardHandler))
+	rt.Start()
+
+	resp, err := rt.RoundTrip(httptest.NewRequest("GET", "http://example.com/users", nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,22 @@
+package gmeter
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//uiPage is the traffic inspector that shows the exchanges and the cassettes
+//
+//go:embed ui.html
+var uiPage []byte
+
+//UI serves the traffic inspector page
+func (rt *RoundTripper) UI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(uiPage)
+}